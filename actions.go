@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// The names of the actions on review assignment messages.
+const (
+	startReviewAction = "start"
+	passReviewAction  = "pass"
+)
+
+// passReasons are the reasons a reviewer can give for passing on a review.
+var passReasons = []string{
+	"Too busy right now",
+	"Not familiar with this code",
+	"Conflict of interest",
+	"Going to be away",
+}
+
+// reviewPass records that someone passed on reviewing a pull request.
+type reviewPass struct {
+	Login  string    `json:"login"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// reviewActions returns the attachment with the "Start review" and "Pass"
+// actions for the message asking someone to review the given pull request.
+func reviewActions(pr *github.PullRequest) slack.Attachment {
+	var options []slack.AttachmentActionOption
+	for _, reason := range passReasons {
+		options = append(options, slack.AttachmentActionOption{
+			Text:  reason,
+			Value: reason,
+		})
+	}
+	return slack.Attachment{
+		CallbackID: prKey(pr),
+		Fallback:   "Review it on GitHub.",
+		Actions: []slack.AttachmentAction{
+			{
+				Name:  startReviewAction,
+				Text:  "Start review",
+				Type:  "button",
+				Style: "primary",
+				Value: startReviewAction,
+			},
+			{
+				Name:    passReviewAction,
+				Text:    "Pass",
+				Type:    "select",
+				Options: options,
+			},
+		},
+	}
+}
+
+// slackActionHandler returns an HTTP handler for the buttons and menus on the
+// bot's Slack messages.
+func slackActionHandler(githubClient *github.Client,
+	slackClient *slack.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readSlackRequest(r)
+		if err != nil {
+			log.WithError(err).Warn("rejected Slack action")
+			http.Error(w, "invalid request", http.StatusUnauthorized)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+		var callback slack.AttachmentActionCallback
+		err = json.Unmarshal([]byte(form.Get("payload")), &callback)
+		if err != nil || len(callback.Actions) == 0 {
+			http.Error(w, "malformed payload", http.StatusBadRequest)
+			return
+		}
+
+		// Slack gives up on requests that take more than three seconds,
+		// and passing can take longer than that, so the message is
+		// updated through the response URL once the action is done.
+		w.WriteHeader(http.StatusOK)
+		go func() {
+			reply := handleReviewAction(githubClient, slackClient,
+				callback)
+			respondToSlack(callback.ResponseURL, map[string]interface{}{
+				"replace_original": true,
+				"text": fmt.Sprintf("%s\n_%s_",
+					callback.OriginalMessage.Text, reply),
+			})
+		}()
+	}
+}
+
+// handleReviewAction acts on a click of "Start review" or "Pass" on a review
+// assignment message, and returns a description of the outcome.
+func handleReviewAction(client *github.Client, slackClient *slack.Client,
+	callback slack.AttachmentActionCallback) string {
+	login, ok := loginForSlackUser(callback.User.ID)
+	if !ok {
+		return "I don't know your GitHub login. Ask an admin to add " +
+			"your Slack ID to the bot's config file."
+	}
+
+	repo, number, err := parsePRKey(callback.CallbackID)
+	if err != nil {
+		log.WithError(err).Warn("bad review action callback")
+		return "Something went wrong."
+	}
+	pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo, number)
+	if err != nil {
+		log.WithError(err).Warnf("unable to get PR %s", callback.CallbackID)
+		return "I couldn't find the pull request on GitHub."
+	}
+	if pr.GetState() != "open" {
+		return "This pull request has been closed."
+	}
+
+	reviewers, err := getRequestedReviewers(client, pr)
+	if err != nil {
+		log.WithError(err).Warnf("unable to get the reviewers of %s",
+			prKey(pr))
+		return "I couldn't check the pull request's reviewers."
+	}
+	requested := false
+	for _, r := range reviewers {
+		requested = requested || r.GetLogin() == login
+	}
+	if !requested {
+		return "You're not a requested reviewer of this pull request anymore."
+	}
+
+	action := callback.Actions[0]
+	switch action.Name {
+	case startReviewAction:
+		startReview(client, slackClient, pr, login)
+		return "You started reviewing this."
+	case passReviewAction:
+		reason := "No reason given"
+		if len(action.SelectedOptions) > 0 {
+			reason = action.SelectedOptions[0].Value
+		}
+		passReview(client, slackClient, pr, login, reason)
+		return fmt.Sprintf("You passed on this (%s).", reason)
+	}
+	return "Something went wrong."
+}
+
+// startReview records that login started reviewing the given pull request, so
+// that they aren't reminded about it, and reacts to the PR to show it.
+func startReview(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest, login string) {
+	log.Infof("%s started reviewing %s", login, prKey(pr))
+	updateState(func(s *botState) {
+		if s.ReviewsStarted == nil {
+			s.ReviewsStarted = map[string]map[string]time.Time{}
+		}
+		if s.ReviewsStarted[prKey(pr)] == nil {
+			s.ReviewsStarted[prKey(pr)] = map[string]time.Time{}
+		}
+		s.ReviewsStarted[prKey(pr)][login] = time.Now()
+	})
+
+	// The vendored GitHub client can't create reactions.
+	url := fmt.Sprintf("/repos/kelda/%s/issues/%d/reactions",
+		*pr.Base.Repo.Name, *pr.Number)
+	req, err := client.NewRequest("POST", url, map[string]string{
+		"content": "eyes",
+	})
+	if err == nil {
+		req.Header.Set("Accept", "application/vnd.github.squirrel-girl-preview")
+		_, err = client.Do(ctx(), req, nil)
+	}
+	if err != nil {
+		log.WithError(err).Warnf("unable to react to %s", prKey(pr))
+	}
+	postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+		":eyes: %s started reviewing.", login))
+}
+
+// passReview removes login as a reviewer of the given pull request, records
+// why, and assigns someone else at the same stage of review.
+func passReview(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest, login, reason string) {
+	log.Infof("%s passed on reviewing %s: %s", login, prKey(pr), reason)
+	updateState(func(s *botState) {
+		if s.ReviewPasses == nil {
+			s.ReviewPasses = map[string][]reviewPass{}
+		}
+		s.ReviewPasses[prKey(pr)] = append(s.ReviewPasses[prKey(pr)],
+			reviewPass{Login: login, Reason: reason, At: time.Now()})
+	})
+	postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+		":arrow_right_hook: %s passed on reviewing (%s).", login, reason))
+
+	remove := map[string][]string{"reviewers": {login}}
+	err := prRequest(client, pr, "DELETE", "requested_reviewers", &remove, nil)
+	if err != nil {
+		log.WithError(err).Warnf("unable to remove %s from %s", login,
+			prKey(pr))
+		return
+	}
+
+	// With the reviewer gone, the usual review logic assigns someone new,
+	// skipping the people who passed.
+	processPullRequest(client, slackClient, pr)
+}
+
+// passedOn returns whether login passed on reviewing the given pull request.
+func passedOn(pr *github.PullRequest, login string) bool {
+	passed := false
+	viewState(func(s *botState) {
+		for _, p := range s.ReviewPasses[prKey(pr)] {
+			passed = passed || p.Login == login
+		}
+	})
+	return passed
+}
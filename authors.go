@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// noreplyEmail matches the private commit emails that GitHub generates for
+// users, e.g. "1234+octocat@users.noreply.github.com". The login is the last
+// capture group.
+var noreplyEmail = regexp.MustCompile(
+	`^(?:\d+\+)?([A-Za-z0-9-]+)@users\.noreply\.github\.com$`)
+
+// getPRAuthors returns the logins of everyone who contributed to the given
+// pull request: the person who opened it, the author and committer of each
+// of its commits, and anyone credited with a Co-authored-by trailer. None of
+// these people should be asked to review the pull request.
+func getPRAuthors(client *github.Client, pr *github.PullRequest) []string {
+	authors := []string{*pr.User.Login}
+	addAuthor := func(login string) {
+		if login != "" && !userInList(&login, authors) {
+			authors = append(authors, login)
+		}
+	}
+
+	commits, err := listPRCommits(client, pr)
+	if err != nil {
+		log.WithError(err).Warnf("unable to list commits for PR %d",
+			*pr.Number)
+		return authors
+	}
+
+	// Map the commit emails we know the GitHub login for, so that
+	// co-authors can usually be resolved without another API call.
+	emailLogins := map[string]string{}
+	for _, c := range commits {
+		if c.Committer != nil {
+			addAuthor(c.Committer.GetLogin())
+		}
+		if c.Author == nil {
+			continue
+		}
+		addAuthor(c.Author.GetLogin())
+		if c.Commit != nil && c.Commit.Author != nil {
+			email := strings.ToLower(c.Commit.Author.GetEmail())
+			emailLogins[email] = c.Author.GetLogin()
+		}
+	}
+
+	for _, c := range commits {
+		for _, value := range parseTrailers(c.Commit.GetMessage(), "Co-authored-by") {
+			addr, err := mail.ParseAddress(value)
+			if err != nil {
+				log.Warnf("ignoring malformed co-author %q on PR %d",
+					value, *pr.Number)
+				continue
+			}
+			email := strings.ToLower(addr.Address)
+			if _, ok := emailLogins[email]; !ok {
+				emailLogins[email] = lookupLoginByEmail(client, email)
+			}
+			addAuthor(emailLogins[email])
+		}
+	}
+	return authors
+}
+
+// listPRCommits returns all of the commits in the given pull request.
+func listPRCommits(client *github.Client, pr *github.PullRequest) (
+	[]*github.RepositoryCommit, error) {
+
+	var all []*github.RepositoryCommit
+	opt := &github.ListOptions{}
+	for {
+		commits, resp, err := client.PullRequests.ListCommits(ctx(), "kelda",
+			*pr.Base.Repo.Name, *pr.Number, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, commits...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// lookupLoginByEmail returns the GitHub login associated with the given email
+// address, or the empty string if it can't be determined.
+func lookupLoginByEmail(client *github.Client, email string) string {
+	if match := noreplyEmail.FindStringSubmatch(email); match != nil {
+		return match[1]
+	}
+
+	query := fmt.Sprintf("%s in:email", email)
+	result, _, err := client.Search.Users(ctx(), query, nil)
+	if err != nil {
+		log.WithError(err).Warnf("unable to look up GitHub user for %s",
+			email)
+		return ""
+	}
+	if len(result.Users) != 1 {
+		return ""
+	}
+	return result.Users[0].GetLogin()
+}
+
+// parseTrailers returns the values of all trailers with the given key (e.g.
+// "Signed-off-by") in the last paragraph of a commit message. Keys are
+// matched case-insensitively, as git does.
+func parseTrailers(message, key string) []string {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	last := paragraphs[len(paragraphs)-1]
+
+	var values []string
+	for _, line := range strings.Split(last, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(parts[0]), key) {
+			values = append(values, strings.TrimSpace(parts[1]))
+		}
+	}
+	return values
+}
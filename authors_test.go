@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTrailers(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		key     string
+		want    []string
+	}{
+		{
+			name:    "no trailers",
+			message: "Fix the thing",
+			key:     "Co-authored-by",
+			want:    nil,
+		},
+		{
+			name: "one trailer",
+			message: "Fix the thing\n\nIt was broken.\n\n" +
+				"Co-authored-by: Ann <ann@example.com>",
+			key:  "Co-authored-by",
+			want: []string{"Ann <ann@example.com>"},
+		},
+		{
+			name: "several trailers with other keys",
+			message: "Fix the thing\n\n" +
+				"Signed-off-by: Bob <bob@example.com>\n" +
+				"Co-authored-by: Ann <ann@example.com>\n" +
+				"co-authored-by:  Cat <cat@example.com> ",
+			key: "Co-authored-by",
+			want: []string{"Ann <ann@example.com>",
+				"Cat <cat@example.com>"},
+		},
+		{
+			name: "only the last paragraph",
+			message: "Fix the thing\n\n" +
+				"Co-authored-by: Ann <ann@example.com>\n\n" +
+				"Signed-off-by: Bob <bob@example.com>",
+			key:  "Co-authored-by",
+			want: nil,
+		},
+		{
+			name: "trailing whitespace",
+			message: "Fix the thing\n\n" +
+				"Signed-off-by: Bob <bob@example.com>\n\n",
+			key:  "Signed-off-by",
+			want: []string{"Bob <bob@example.com>"},
+		},
+	}
+
+	for _, test := range tests {
+		got := parseTrailers(test.message, test.key)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
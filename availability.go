@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// isAway returns whether the given user has said they're unavailable at time
+// t, either in the config file or with a bot command.
+func isAway(login string, t time.Time) bool {
+	periods := append([]awayPeriod{}, config.Users[login].Away...)
+	viewState(func(s *botState) {
+		periods = append(periods, s.Away[login]...)
+	})
+
+	for _, p := range periods {
+		if p.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// openReviewCounts caches how many open review requests each person holds, so
+// that choosing a reviewer doesn't cost a search for every candidate. Entries
+// expire after openReviewCountTTL, which matches how often runReview runs.
+var (
+	openReviewCounts     = map[string]openReviewCount{}
+	openReviewCountsLock sync.Mutex
+	openReviewCountTTL   = 10 * time.Minute
+)
+
+type openReviewCount struct {
+	count   int
+	fetched time.Time
+}
+
+// atCapacity returns whether the given user already holds as many open review
+// requests as they're allowed.
+func atCapacity(client *github.Client, login string) bool {
+	limit := maxReviews(login)
+	if limit == 0 {
+		return false
+	}
+
+	count, err := countOpenReviews(client, login)
+	if err != nil {
+		log.WithError(err).Warnf("unable to count open reviews for %s",
+			login)
+		return false
+	}
+	return count >= limit
+}
+
+// countOpenReviews returns the number of open pull requests in the
+// organization that are waiting for a review from the given user.
+func countOpenReviews(client *github.Client, login string) (int, error) {
+	openReviewCountsLock.Lock()
+	defer openReviewCountsLock.Unlock()
+	if cached, ok := openReviewCounts[login]; ok &&
+		time.Since(cached.fetched) < openReviewCountTTL {
+		return cached.count, nil
+	}
+
+	query := fmt.Sprintf("org:kelda is:pr is:open review-requested:%s", login)
+	result, _, err := client.Search.Issues(ctx(), query, nil)
+	if err != nil {
+		return 0, err
+	}
+	openReviewCounts[login] = openReviewCount{
+		count:   result.GetTotal(),
+		fetched: time.Now(),
+	}
+	return result.GetTotal(), nil
+}
+
+// noteReviewAssigned counts a review that was just requested from the given
+// user, so that the cached count stays accurate until it expires.
+func noteReviewAssigned(login string) {
+	openReviewCountsLock.Lock()
+	defer openReviewCountsLock.Unlock()
+	if cached, ok := openReviewCounts[login]; ok {
+		cached.count++
+		openReviewCounts[login] = cached
+	}
+}
+
+// isAvailable returns whether the given user can be assigned a new review.
+func isAvailable(client *github.Client, login string) bool {
+	return !isAway(login, time.Now()) && !atCapacity(client, login)
+}
+
+// awayCommand handles "away START END", which marks the person who issued the
+// command as unavailable from START through END. "away until END" does the same
+// starting today.
+func awayCommand(client *github.Client, req commandRequest) (string, error) {
+	if len(req.args) != 3 {
+		return "", errors.New("usage: away YYYY-MM-DD YYYY-MM-DD, " +
+			"or away until YYYY-MM-DD")
+	}
+	startArg := req.args[1]
+	if strings.ToLower(startArg) == "until" {
+		startArg = time.Now().Format(dateFormat)
+	}
+	start, err := parseDate(startArg)
+	if err != nil {
+		return "", fmt.Errorf("invalid start date: %s", err)
+	}
+	end, err := parseDate(req.args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid end date: %s", err)
+	}
+	if end.Before(start.Time) {
+		return "", errors.New("the end date is before the start date")
+	}
+
+	period := awayPeriod{Start: start, End: end}
+	updateState(func(s *botState) {
+		if s.Away == nil {
+			s.Away = map[string][]awayPeriod{}
+		}
+		s.Away[req.login] = append(s.Away[req.login], period)
+	})
+	return fmt.Sprintf("OK, %s won't be assigned reviews from %s through %s.",
+		req.login, start, end), nil
+}
+
+// backCommand handles "back", which clears all of the away periods that the
+// person who issued the command set with bot commands.
+func backCommand(client *github.Client, req commandRequest) (string, error) {
+	updateState(func(s *botState) {
+		delete(s.Away, req.login)
+	})
+	return fmt.Sprintf("Welcome back, %s!", req.login), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAwayPeriodContains(t *testing.T) {
+	period := awayPeriod{
+		Start: date{time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		End:   date{time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC)},
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"before", time.Date(2026, 10, 18, 23, 59, 0, 0, time.UTC), false},
+		{"first day", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), true},
+		{"middle", time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC), true},
+		{"end of last day", time.Date(2026, 10, 21, 23, 59, 0, 0, time.UTC), true},
+		{"after", time.Date(2026, 10, 22, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, test := range tests {
+		if got := period.contains(test.t); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAwayCommand(t *testing.T) {
+	defer useTestState(t)()
+
+	today, _ := parseDate(time.Now().Format(dateFormat))
+	nextWeek := date{today.AddDate(0, 0, 7)}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []awayPeriod
+		wantErr bool
+	}{
+		{
+			name: "start and end",
+			args: []string{"away", "2026-10-19", "2026-10-21"},
+			want: []awayPeriod{{
+				Start: date{time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+				End:   date{time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC)},
+			}},
+		},
+		{
+			name: "until",
+			args: []string{"away", "until", nextWeek.String()},
+			want: []awayPeriod{{Start: today, End: nextWeek}},
+		},
+		{
+			name:    "until a past date",
+			args:    []string{"away", "until", "2020-01-01"},
+			wantErr: true,
+		},
+		{
+			name:    "end before start",
+			args:    []string{"away", "2026-10-21", "2026-10-19"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid date",
+			args:    []string{"away", "2026-10-19", "friday"},
+			wantErr: true,
+		},
+		{
+			name:    "missing end",
+			args:    []string{"away", "2026-10-19"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		state = botState{}
+		_, err := awayCommand(nil, commandRequest{login: "ann", args: test.args})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error: %v", test.name, err,
+				test.wantErr)
+		}
+		if got := state.Away["ann"]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// backportCommand handles "backport BRANCH" (also written "/backport BRANCH"),
+// which cherry-picks a pull request onto BRANCH and opens a new pull request
+// with the result. If the pull request isn't merged yet, the backport happens
+// once it is.
+func backportCommand(client *github.Client, req commandRequest) (string, error) {
+	if req.pr == nil {
+		return "", errors.New("backport can only be used on a pull request")
+	}
+	if len(req.args) != 2 {
+		return "", errors.New("usage: backport BRANCH")
+	}
+	members, _ := getTeamMembers(client)
+	if !userInList(&req.login, members) {
+		return "", errors.New("only team members can request backports")
+	}
+
+	branch := req.args[1]
+	_, _, err := client.Git.GetRef(ctx(), "kelda", *req.pr.Base.Repo.Name,
+		"heads/"+branch)
+	if err != nil {
+		return "", fmt.Errorf("there's no branch named %s", branch)
+	}
+
+	if !req.pr.GetMerged() {
+		updateState(func(s *botState) {
+			if s.Backports == nil {
+				s.Backports = map[string][]string{}
+			}
+			key := prKey(req.pr)
+			s.Backports[key] = append(s.Backports[key], branch)
+		})
+		return fmt.Sprintf("I'll backport this to `%s` once it's merged.",
+			branch), nil
+	}
+	return backportPR(client, req.pr, branch)
+}
+
+// runPendingBackports performs the backports that were requested for the given
+// pull request before it was merged, and comments with the results.
+func runPendingBackports(client *github.Client, pr *github.PullRequest) {
+	var branches []string
+	updateState(func(s *botState) {
+		branches = s.Backports[prKey(pr)]
+		delete(s.Backports, prKey(pr))
+	})
+
+	for _, branch := range branches {
+		reply, err := backportPR(client, pr, branch)
+		if err != nil {
+			reply = fmt.Sprintf("I couldn't backport this to `%s`: %s",
+				branch, err)
+		}
+		commentOnPR(client, pr, reply)
+	}
+}
+
+// runMissedBackports performs the pending backports of pull requests that are
+// no longer open, in case the webhook for their merge was missed, and drops the
+// ones whose pull requests were closed without being merged. open holds the
+// prKey of every open pull request.
+func runMissedBackports(client *github.Client, open map[string]bool) {
+	var closed []string
+	viewState(func(s *botState) {
+		for key := range s.Backports {
+			if !open[key] {
+				closed = append(closed, key)
+			}
+		}
+	})
+
+	for _, key := range closed {
+		repo, number, err := parsePRKey(key)
+		if err != nil {
+			log.WithError(err).Warnf("bad backport key %s", key)
+			continue
+		}
+		pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo, number)
+		if err != nil {
+			log.WithError(err).Warnf("unable to get PR %s", key)
+			continue
+		}
+		switch {
+		case pr.GetMerged():
+			runPendingBackports(client, pr)
+		case pr.GetState() == "closed":
+			updateState(func(s *botState) {
+				delete(s.Backports, key)
+			})
+		}
+	}
+}
+
+// backportPR cherry-picks the changes of a merged pull request onto branch,
+// opens a pull request with the result, and requests reviews from the people
+// who reviewed the original. It returns a message describing the outcome. If
+// the cherry-pick conflicts, no pull request is opened and the message
+// explains how to do the backport by hand.
+//
+// The cherry-pick is done entirely with the git data API: a file is only
+// taken from the pull request if the target branch still has the version that
+// the pull request started from. Any other difference is a conflict.
+func backportPR(client *github.Client, pr *github.PullRequest,
+	branch string) (string, error) {
+	repo := *pr.Base.Repo.Name
+	log.Infof("Backporting %s to %s", prKey(pr), branch)
+
+	merged, _, err := client.Git.GetCommit(ctx(), "kelda", repo,
+		pr.GetMergeCommitSHA())
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the merge commit: %s", err)
+	}
+	before, err := commitBeforePR(client, pr, merged)
+	if err != nil {
+		return "", err
+	}
+	targetRef, _, err := client.Git.GetRef(ctx(), "kelda", repo, "heads/"+branch)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get branch %s: %s", branch, err)
+	}
+	target, _, err := client.Git.GetCommit(ctx(), "kelda", repo,
+		targetRef.Object.GetSHA())
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the head of %s: %s", branch, err)
+	}
+
+	beforeFiles, err := getTreeFiles(client, repo, before.Tree.GetSHA())
+	if err != nil {
+		return "", err
+	}
+	mergedFiles, err := getTreeFiles(client, repo, merged.Tree.GetSHA())
+	if err != nil {
+		return "", err
+	}
+	targetFiles, err := getTreeFiles(client, repo, target.Tree.GetSHA())
+	if err != nil {
+		return "", err
+	}
+
+	// Apply each file the pull request changed to the target branch.
+	changed := map[string]bool{}
+	for path, entry := range beforeFiles {
+		if mergedFiles[path].GetSHA() != entry.GetSHA() {
+			changed[path] = true
+		}
+	}
+	for path, entry := range mergedFiles {
+		if beforeFiles[path].GetSHA() != entry.GetSHA() {
+			changed[path] = true
+		}
+	}
+	var conflicts []string
+	var entries []backportTreeEntry
+	for path := range changed {
+		targetSHA := targetFiles[path].GetSHA()
+		switch {
+		case targetSHA == mergedFiles[path].GetSHA():
+			// The branch already has this change.
+		case targetSHA != beforeFiles[path].GetSHA():
+			conflicts = append(conflicts, path)
+		case mergedFiles[path] == nil:
+			entry := beforeFiles[path]
+			entries = append(entries, backportTreeEntry{
+				Path: path,
+				Mode: entry.GetMode(),
+				Type: entry.GetType(),
+			})
+		default:
+			entry := mergedFiles[path]
+			entries = append(entries, backportTreeEntry{
+				Path: path,
+				Mode: entry.GetMode(),
+				Type: entry.GetType(),
+				SHA:  entry.SHA,
+			})
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return backportInstructions(pr, merged, branch, conflicts), nil
+	}
+
+	// Only the changed files are sent, on top of the target branch's tree,
+	// so that nothing else on the branch can be lost.
+	tree, err := createBackportTree(client, repo, target.Tree.GetSHA(),
+		entries)
+	if err != nil {
+		return "", fmt.Errorf("couldn't create the backported tree: %s", err)
+	}
+
+	message := fmt.Sprintf("%s (#%d)\n\nBackport of #%d to %s.\n\n"+
+		"(cherry picked from commit %s)", pr.GetTitle(), *pr.Number,
+		*pr.Number, branch, merged.GetSHA())
+	commit, _, err := client.Git.CreateCommit(ctx(), "kelda", repo,
+		&github.Commit{
+			Message: &message,
+			Tree:    tree,
+			Parents: []github.Commit{{SHA: target.SHA}},
+			Author:  merged.Author,
+		})
+	if err != nil {
+		return "", fmt.Errorf("couldn't create the backport commit: %s", err)
+	}
+
+	newBranch := fmt.Sprintf("backport-%d-to-%s", *pr.Number, branch)
+	_, _, err = client.Git.CreateRef(ctx(), "kelda", repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + newBranch),
+		Object: &github.GitObject{SHA: commit.SHA},
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't create branch %s: %s", newBranch, err)
+	}
+
+	body := fmt.Sprintf("Backport of #%d to `%s`.\n\n%s", *pr.Number,
+		branch, pr.GetBody())
+	backport, _, err := client.PullRequests.Create(ctx(), "kelda", repo,
+		&github.NewPullRequest{
+			Title: github.String(fmt.Sprintf("[%s] %s", branch, pr.GetTitle())),
+			Head:  &newBranch,
+			Base:  &branch,
+			Body:  &body,
+		})
+	if err != nil {
+		return "", fmt.Errorf("couldn't open the backport PR: %s", err)
+	}
+
+	requestOriginalReviewers(client, pr, backport)
+	return fmt.Sprintf("I opened #%d to backport this to `%s`.",
+		backport.GetNumber(), branch), nil
+}
+
+// commitBeforePR returns the commit on the base branch just before the given
+// merged pull request's changes.
+func commitBeforePR(client *github.Client, pr *github.PullRequest,
+	merged *github.Commit) (*github.Commit, error) {
+	if len(merged.Parents) == 0 {
+		return nil, errors.New("the merge commit has no parents")
+	}
+
+	// A merge commit or a squashed commit holds all of the pull request's
+	// changes, relative to its first parent. A rebase instead puts each of
+	// the pull request's commits on the base branch, ending with the merge
+	// commit, so we need to walk back past all of them.
+	steps := 1
+	if len(merged.Parents) == 1 && pr.GetCommits() > 1 {
+		commits, err := listPRCommits(client, pr)
+		if err == nil && len(commits) > 0 &&
+			commits[len(commits)-1].Commit.GetMessage() == merged.GetMessage() {
+			steps = len(commits)
+		}
+	}
+
+	commit := merged
+	for i := 0; i < steps; i++ {
+		if len(commit.Parents) == 0 {
+			return nil, errors.New("ran out of history before the PR")
+		}
+		parent, _, err := client.Git.GetCommit(ctx(), "kelda",
+			*pr.Base.Repo.Name, commit.Parents[0].GetSHA())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get commit %s: %s",
+				commit.Parents[0].GetSHA(), err)
+		}
+		commit = parent
+	}
+	return commit, nil
+}
+
+// getTreeFiles returns the files (and submodules) in the given tree, keyed by
+// path. GitHub truncates the listings of very large trees, and a partial
+// listing would hide changes, so it's an error. The vendored client doesn't
+// report truncation, so the tree is requested directly.
+func getTreeFiles(client *github.Client, repo, sha string) (
+	map[string]*github.TreeEntry, error) {
+	url := fmt.Sprintf("repos/kelda/%s/git/trees/%s?recursive=1", repo, sha)
+	req, err := client.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var tree struct {
+		Entries   []github.TreeEntry `json:"tree"`
+		Truncated bool               `json:"truncated"`
+	}
+	if _, err := client.Do(ctx(), req, &tree); err != nil {
+		return nil, fmt.Errorf("couldn't get tree %s: %s", sha, err)
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("tree %s is too big for GitHub to list", sha)
+	}
+
+	files := map[string]*github.TreeEntry{}
+	for i, entry := range tree.Entries {
+		if entry.GetType() != "tree" {
+			files[entry.GetPath()] = &tree.Entries[i]
+		}
+	}
+	return files, nil
+}
+
+// backportTreeEntry is a change to a file in a backport's tree. A nil SHA
+// deletes the file. The vendored client's TreeEntry can't express deletions,
+// since it leaves out nil SHAs.
+type backportTreeEntry struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	SHA  *string `json:"sha"`
+}
+
+// createBackportTree creates a tree that's the given base tree with entries
+// applied to it.
+func createBackportTree(client *github.Client, repo, baseTree string,
+	entries []backportTreeEntry) (*github.Tree, error) {
+	url := fmt.Sprintf("repos/kelda/%s/git/trees", repo)
+	req, err := client.NewRequest("POST", url, map[string]interface{}{
+		"base_tree": baseTree,
+		"tree":      entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tree github.Tree
+	if _, err := client.Do(ctx(), req, &tree); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// backportInstructions returns a message explaining how to backport a pull
+// request by hand, for when the bot's cherry-pick conflicts.
+func backportInstructions(pr *github.PullRequest, merged *github.Commit,
+	branch string, conflicts []string) string {
+	pickFlags := "-x"
+	if len(merged.Parents) > 1 {
+		pickFlags = "-x -m 1"
+	}
+	return fmt.Sprintf("I couldn't backport this to `%s` because these "+
+		"files conflict:\n\n- %s\n\nTo backport it by hand:\n\n"+
+		"```\ngit fetch origin\n"+
+		"git checkout -b backport-%d-to-%s origin/%s\n"+
+		"git cherry-pick %s %s\n"+
+		"# Resolve the conflicts, then run git cherry-pick --continue.\n"+
+		"git push origin backport-%d-to-%s\n```\n\n"+
+		"Then open a pull request against `%s`.",
+		branch, strings.Join(conflicts, "\n- "), *pr.Number, branch, branch,
+		pickFlags, merged.GetSHA(), *pr.Number, branch, branch)
+}
+
+// requestOriginalReviewers asks the people who reviewed the original pull
+// request to review its backport.
+func requestOriginalReviewers(client *github.Client, original,
+	backport *github.PullRequest) {
+	reviews, err := getReviews(client, original)
+	if err != nil {
+		log.WithError(err).Warnf("unable to get the reviewers of %s",
+			prKey(original))
+		return
+	}
+
+	var reviewers []string
+	for _, review := range reviews {
+		login := review.User.GetLogin()
+		if login != *original.User.Login && !userInList(&login, reviewers) {
+			reviewers = append(reviewers, login)
+		}
+	}
+	if len(reviewers) == 0 {
+		return
+	}
+
+	post := map[string][]string{"reviewers": reviewers}
+	err = prRequest(client, backport, "POST", "requested_reviewers", &post, nil)
+	if err != nil {
+		log.WithError(err).Warnf("unable to request reviews of %s",
+			prKey(backport))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestGetTreeFiles(t *testing.T) {
+	mux := http.NewServeMux()
+	serveJSON(mux, "/repos/kelda/bot/git/trees/small", `{"tree": [
+		{"path": "cmd", "type": "tree", "sha": "1"},
+		{"path": "cmd/main.go", "type": "blob", "sha": "2"}]}`)
+	serveJSON(mux, "/repos/kelda/bot/git/trees/big", `{"tree": [
+		{"path": "a.go", "type": "blob", "sha": "3"}], "truncated": true}`)
+	client, closeGitHub := newFakeGitHub(mux)
+	defer closeGitHub()
+
+	files, err := getTreeFiles(client, "bot", "small")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(files) != 1 || files["cmd/main.go"].GetSHA() != "2" {
+		t.Errorf("got %v, want only cmd/main.go", files)
+	}
+
+	if _, err := getTreeFiles(client, "bot", "big"); err == nil {
+		t.Error("got no error for a truncated tree")
+	}
+}
+
+func TestCreateBackportTree(t *testing.T) {
+	var got map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/kelda/bot/git/trees",
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &got)
+			w.Write([]byte(`{"sha": "new"}`))
+		})
+	client, closeGitHub := newFakeGitHub(mux)
+	defer closeGitHub()
+
+	tree, err := createBackportTree(client, "bot", "base", []backportTreeEntry{
+		{Path: "changed.go", Mode: "100644", Type: "blob",
+			SHA: github.String("abc")},
+		{Path: "deleted.go", Mode: "100644", Type: "blob"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tree.GetSHA() != "new" {
+		t.Errorf("got tree %s, want new", tree.GetSHA())
+	}
+
+	// Deleted files must be sent with a null SHA.
+	want := map[string]interface{}{
+		"base_tree": "base",
+		"tree": []interface{}{
+			map[string]interface{}{"path": "changed.go", "mode": "100644",
+				"type": "blob", "sha": "abc"},
+			map[string]interface{}{"path": "deleted.go", "mode": "100644",
+				"type": "blob", "sha": nil},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got request %v, want %v", got, want)
+	}
+}
+
+func TestRunMissedBackports(t *testing.T) {
+	defer useTestState(t)()
+	state.Backports = map[string][]string{
+		"bot#1": {"release-1.0"},
+		"bot#2": {"release-1.0"},
+		"bot#3": {"release-1.0"},
+	}
+
+	var comment string
+	mux := http.NewServeMux()
+	serveJSON(mux, "/repos/kelda/bot/pulls/2", `{"number": 2,
+		"state": "closed", "merged": false, "base": {"repo": {"name": "bot"}}}`)
+	serveJSON(mux, "/repos/kelda/bot/pulls/3", `{"number": 3,
+		"state": "closed", "merged": true, "merge_commit_sha": "abc",
+		"base": {"repo": {"name": "bot"}}}`)
+	mux.HandleFunc("/repos/kelda/bot/issues/3/comments",
+		func(w http.ResponseWriter, r *http.Request) {
+			var body struct{ Body string }
+			json.NewDecoder(r.Body).Decode(&body)
+			comment = body.Body
+			w.Write([]byte(`{}`))
+		})
+	client, closeGitHub := newFakeGitHub(mux)
+	defer closeGitHub()
+
+	runMissedBackports(client, map[string]bool{"bot#1": true})
+
+	// The open PR keeps its backports, the one closed without merging
+	// loses them, and the merged one has them done. The merge commit
+	// doesn't exist on the fake, so the backport fails.
+	want := map[string][]string{"bot#1": {"release-1.0"}}
+	if !reflect.DeepEqual(state.Backports, want) {
+		t.Errorf("got backports %v, want %v", state.Backports, want)
+	}
+	if !strings.Contains(comment, "I couldn't backport this to `release-1.0`") {
+		t.Errorf("got comment %q, want a failed backport", comment)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// handleMergedPR deletes the head branch of a merged pull request, if the
+// branch is in the same repository as the base, isn't protected or excluded
+// from cleanup, and isn't the base of another open pull request. The pull
+// request is fetched again rather than trusting the webhook payload, since a
+// deleted branch can't be brought back.
+func handleMergedPR(client *github.Client, event *github.PullRequest) {
+	pr, _, err := client.PullRequests.Get(ctx(), "kelda",
+		*event.Base.Repo.Name, event.GetNumber())
+	if err != nil {
+		log.WithError(err).Warnf("unable to get merged PR %s", prKey(event))
+		return
+	}
+	if !pr.GetMerged() {
+		return
+	}
+
+	if pr.Head.Repo == nil || pr.Head.Repo.GetID() != pr.Base.Repo.GetID() {
+		// The branch is in a fork, which we can't delete.
+		return
+	}
+
+	repo := *pr.Base.Repo.Name
+	branchName := pr.Head.GetRef()
+	if branchName == pr.Base.Repo.GetDefaultBranch() || branchExcluded(branchName) {
+		return
+	}
+
+	branch, _, err := client.Repositories.GetBranch(ctx(), "kelda", repo, branchName)
+	if err != nil {
+		// The branch was probably already deleted by its author.
+		log.WithError(err).Debugf("unable to get branch %s of %s",
+			branchName, repo)
+		return
+	}
+	if branch.GetProtected() {
+		return
+	}
+
+	// Deleting the base branch of a stacked pull request would close it.
+	opts := &github.PullRequestListOptions{Base: branchName}
+	stacked, _, err := client.PullRequests.List(ctx(), "kelda", repo, opts)
+	if err != nil {
+		log.WithError(err).Warnf("unable to list PRs based on %s of %s",
+			branchName, repo)
+		return
+	}
+	if len(stacked) > 0 {
+		log.Infof("Not deleting branch %s of %s, which is the base of "+
+			"%d open PRs", branchName, repo, len(stacked))
+		return
+	}
+	deleteBranch(client, repo, branchName)
+}
+
+// branchExcluded returns whether the given branch matches one of the patterns
+// that are excluded from cleanup.
+func branchExcluded(branch string) bool {
+	for _, pattern := range config.BranchCleanup.Exclude {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBranch deletes the given branch, and returns whether it succeeded.
+func deleteBranch(client *github.Client, repo, branch string) bool {
+	log.Infof("Deleting branch %s of %s", branch, repo)
+	_, err := client.Git.DeleteRef(ctx(), "kelda", repo, "heads/"+branch)
+	if err != nil {
+		log.WithError(err).Warnf("unable to delete branch %s of %s",
+			branch, repo)
+		return false
+	}
+	return true
+}
+
+// staleBranch is a branch found by sweepStaleBranches.
+type staleBranch struct {
+	repo, name string
+	lastCommit time.Time
+}
+
+// sweepStaleBranches finds branches across the organization that have no open
+// pull request and no commits in the last config.BranchCleanup.StaleDays days.
+// The branches are reported in Slack, and deleted if
+// config.BranchCleanup.DeleteStale is set.
+func sweepStaleBranches(client *github.Client, slackClient *slack.Client) {
+	cleanup := config.BranchCleanup
+	if cleanup.StaleDays == 0 {
+		return
+	}
+
+	repos, err := listOrgRepos(client)
+	if err != nil {
+		log.WithError(err).Warn("unable to list repos for the branch sweep")
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -cleanup.StaleDays)
+	var stale []staleBranch
+	for _, repo := range repos {
+		stale = append(stale, findStaleBranches(client, repo, cutoff)...)
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	verb := "would be deleted"
+	if cleanup.DeleteStale {
+		verb = "were deleted"
+	}
+	lines := []string{fmt.Sprintf("These branches have had no open pull "+
+		"request and no commits in %d days, so they %s:",
+		cleanup.StaleDays, verb)}
+	for _, b := range stale {
+		line := fmt.Sprintf("• %s `%s` (last commit %s)", b.repo, b.name,
+			b.lastCommit.Format(dateFormat))
+		if cleanup.DeleteStale && !deleteBranch(client, b.repo, b.name) {
+			line += " _couldn't be deleted_"
+		}
+		lines = append(lines, line)
+	}
+
+	report := strings.Join(lines, "\n")
+	if cleanup.ReportChannel != "" {
+		postToChannel(slackClient, cleanup.ReportChannel, report)
+	} else {
+		log.Info(report)
+	}
+}
+
+// findStaleBranches returns the branches of repo that aren't the head or base
+// of an open pull request, and whose last commit is before cutoff. The default
+// branch, protected branches, and excluded branches are never returned.
+func findStaleBranches(client *github.Client, repo *github.Repository,
+	cutoff time.Time) []staleBranch {
+	name := repo.GetName()
+	prs, err := listOpenPRs(client, name)
+	if err != nil {
+		log.WithError(err).Warnf("unable to list PRs of %s", name)
+		return nil
+	}
+	withPR := map[string]bool{}
+	for _, pr := range prs {
+		withPR[pr.Base.GetRef()] = true
+		if pr.Head.Repo != nil && pr.Head.Repo.GetID() == repo.GetID() {
+			withPR[pr.Head.GetRef()] = true
+		}
+	}
+
+	var stale []staleBranch
+	opts := &github.ListOptions{}
+	for {
+		branches, resp, err := client.Repositories.ListBranches(ctx(),
+			"kelda", name, opts)
+		if err != nil {
+			log.WithError(err).Warnf("unable to list branches of %s", name)
+			return nil
+		}
+		for _, b := range branches {
+			branch := b.GetName()
+			if branch == repo.GetDefaultBranch() || b.GetProtected() ||
+				withPR[branch] || branchExcluded(branch) {
+				continue
+			}
+
+			commit, _, err := client.Repositories.GetCommit(ctx(), "kelda",
+				name, b.Commit.GetSHA())
+			if err != nil {
+				log.WithError(err).Warnf("unable to get the last "+
+					"commit on %s of %s", branch, name)
+				continue
+			}
+			committed := commit.Commit.Committer.GetDate()
+			if committed.Before(cutoff) {
+				stale = append(stale, staleBranch{name, branch, committed})
+			}
+		}
+		if resp.NextPage == 0 {
+			return stale
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// listOrgRepos returns all of the repositories in the Kelda organization.
+func listOrgRepos(client *github.Client) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	opts := &github.RepositoryListByOrgOptions{}
+	for {
+		page, resp, err := client.Repositories.ListByOrg(ctx(), "kelda", opts)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
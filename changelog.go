@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// changelogSections are the sections of a changelog, in order, along with the
+// labels whose pull requests go in each. Pull requests with none of the labels
+// go under otherChanges.
+var changelogSections = []struct {
+	title  string
+	labels []string
+}{
+	{"Features", []string{"feature", "enhancement"}},
+	{"Bug Fixes", []string{"bugfix", "bug"}},
+	{"Documentation", []string{"docs", "documentation"}},
+}
+
+const otherChanges = "Other Changes"
+
+// prNumberPattern matches the pull request number in the message of a merge
+// commit ("Merge pull request #12 from ...") or a squashed commit ("Title
+// (#12)").
+var prNumberPattern = regexp.MustCompile(`^Merge pull request #(\d+) |\(#(\d+)\)$`)
+
+// changelogCommand handles "changelog [REPO] FROM..TO", which replies with a
+// Markdown changelog of the pull requests merged between two tags. On a pull
+// request, REPO defaults to the pull request's repository; otherwise it
+// defaults to the main Kelda repository.
+func changelogCommand(client *github.Client, req commandRequest) (string, error) {
+	repo := githubRepo
+	if req.pr != nil {
+		repo = *req.pr.Base.Repo.Name
+	}
+	var tagRange string
+	switch len(req.args) {
+	case 2:
+		tagRange = req.args[1]
+	case 3:
+		repo, tagRange = req.args[1], req.args[2]
+	default:
+		return "", errors.New("usage: changelog [REPO] FROM..TO")
+	}
+	tags := strings.SplitN(tagRange, "..", 2)
+	if len(tags) != 2 || tags[0] == "" || tags[1] == "" {
+		return "", errors.New("usage: changelog [REPO] FROM..TO")
+	}
+	return generateChangelog(client, repo, tags[0], tags[1])
+}
+
+// generateChangelog returns a Markdown changelog of the pull requests merged
+// into repo between the from and to refs, grouped by label, crediting their
+// authors and highlighting first-time contributors.
+func generateChangelog(client *github.Client, repo, from, to string) (
+	string, error) {
+	commits, err := commitsBetween(client, repo, from, to)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("there are no commits between %s and %s",
+			from, to)
+	}
+
+	var numbers []int
+	seen := map[int]bool{}
+	for _, commit := range commits {
+		for _, number := range prsForCommit(client, repo, commit) {
+			if !seen[number] {
+				seen[number] = true
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	sort.Ints(numbers)
+
+	base, _, err := client.Repositories.GetCommit(ctx(), "kelda", repo, from)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get %s: %s", from, err)
+	}
+	since := base.Commit.Committer.GetDate().Format(dateFormat)
+
+	sections := map[string][]string{}
+	var newcomers, unchecked []string
+	checked := map[string]bool{}
+	for _, number := range numbers {
+		issue, _, err := client.Issues.Get(ctx(), "kelda", repo, number)
+		if err != nil {
+			return "", fmt.Errorf("couldn't get #%d: %s", number, err)
+		}
+		author := issue.User.GetLogin()
+		section := changelogSection(issue.Labels)
+		sections[section] = append(sections[section], fmt.Sprintf(
+			"- %s (#%d) by @%s", issue.GetTitle(), number, author))
+
+		if checked[author] {
+			continue
+		}
+		checked[author] = true
+		first, err := isFirstContribution(client, repo, author, since)
+		if err != nil {
+			log.WithError(err).Warnf("unable to check whether %s is a "+
+				"first-time contributor", author)
+			unchecked = append(unchecked, "@"+author)
+		} else if first {
+			newcomers = append(newcomers, author)
+		}
+	}
+
+	var titles []string
+	for _, s := range changelogSections {
+		titles = append(titles, s.title)
+	}
+	var out []string
+	for _, title := range append(titles, otherChanges) {
+		if len(sections[title]) == 0 {
+			continue
+		}
+		out = append(out, "## "+title, "")
+		out = append(out, sections[title]...)
+		out = append(out, "")
+	}
+	if len(newcomers) > 0 || len(unchecked) > 0 {
+		out = append(out, "## First-Time Contributors", "")
+	}
+	if len(newcomers) > 0 {
+		for _, login := range newcomers {
+			out = append(out, fmt.Sprintf("- @%s", login))
+		}
+		out = append(out, "", "Thanks, and welcome!", "")
+	}
+	if len(unchecked) > 0 {
+		// Leaving someone out of the welcome silently would be worse
+		// than saying that the list may be incomplete.
+		out = append(out, fmt.Sprintf("I couldn't check whether these "+
+			"people are new: %s.", strings.Join(unchecked, ", ")))
+	}
+	return strings.TrimSpace(strings.Join(out, "\n")), nil
+}
+
+// commitsBetween returns the commits reachable from to but not from. The
+// vendored client doesn't support paging comparisons, so the comparison is
+// requested directly, a page at a time.
+func commitsBetween(client *github.Client, repo, from, to string) (
+	[]github.RepositoryCommit, error) {
+	var commits []github.RepositoryCommit
+	page := 1
+	for {
+		url := fmt.Sprintf("repos/kelda/%s/compare/%s...%s?per_page=100&page=%d",
+			repo, from, to, page)
+		req, err := client.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		var comparison github.CommitsComparison
+		resp, err := client.Do(ctx(), req, &comparison)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't compare %s with %s: %s",
+				from, to, err)
+		}
+		commits = append(commits, comparison.Commits...)
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+		page = resp.NextPage
+	}
+}
+
+// prsForCommit returns the numbers of the pull requests that the given commit
+// came from. The number is taken from the commit message when it was merged or
+// squashed; otherwise GitHub is asked.
+func prsForCommit(client *github.Client, repo string,
+	commit github.RepositoryCommit) []int {
+	message := strings.SplitN(commit.Commit.GetMessage(), "\n", 2)[0]
+	if match := prNumberPattern.FindStringSubmatch(message); match != nil {
+		number, _ := strconv.Atoi(match[1] + match[2])
+		return []int{number}
+	}
+
+	// The vendored GitHub client doesn't support listing the pull requests
+	// associated with a commit.
+	url := fmt.Sprintf("/repos/kelda/%s/commits/%s/pulls", repo, commit.GetSHA())
+	req, err := client.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Accept", "application/vnd.github.groot-preview+json")
+	var prs []github.PullRequest
+	if _, err := client.Do(ctx(), req, &prs); err != nil {
+		return nil
+	}
+
+	var numbers []int
+	for _, pr := range prs {
+		if pr.MergedAt != nil {
+			numbers = append(numbers, pr.GetNumber())
+		}
+	}
+	return numbers
+}
+
+// changelogSection returns the changelog section for a pull request with the
+// given labels.
+func changelogSection(labels []github.Label) string {
+	for _, s := range changelogSections {
+		for _, label := range labels {
+			name := strings.ToLower(label.GetName())
+			if userInList(&name, s.labels) {
+				return s.title
+			}
+		}
+	}
+	return otherChanges
+}
+
+// isFirstContribution returns whether login had no pull requests merged into
+// repo before the given date.
+func isFirstContribution(client *github.Client, repo, login, before string) (
+	bool, error) {
+	query := fmt.Sprintf("repo:kelda/%s is:pr is:merged author:%s merged:<%s",
+		repo, login, before)
+	result, _, err := client.Search.Issues(ctx(), query, nil)
+	if err != nil {
+		return false, err
+	}
+	return result.GetTotal() == 0, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestPRNumberPattern(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Merge pull request #12 from ann/fix", "12"},
+		{"Fix the scheduler (#345)", "345"},
+		{"Fix #12 in the scheduler", ""},
+		{"Revert (#12) in the scheduler", ""},
+		{"Merge branch 'master' into fix", ""},
+	}
+
+	for _, test := range tests {
+		got := ""
+		if match := prNumberPattern.FindStringSubmatch(test.message); match != nil {
+			got = match[1] + match[2]
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.message, got, test.want)
+		}
+	}
+}
+
+func TestChangelogSection(t *testing.T) {
+	labels := func(names ...string) []github.Label {
+		var result []github.Label
+		for _, name := range names {
+			result = append(result, github.Label{Name: github.String(name)})
+		}
+		return result
+	}
+	tests := []struct {
+		name   string
+		labels []github.Label
+		want   string
+	}{
+		{"no labels", nil, otherChanges},
+		{"unknown label", labels("needs-review"), otherChanges},
+		{"feature", labels("enhancement"), "Features"},
+		{"case insensitive", labels("Bug"), "Bug Fixes"},
+		{"first section wins", labels("docs", "bug"), "Bug Fixes"},
+	}
+
+	for _, test := range tests {
+		if got := changelogSection(test.labels); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGenerateChangelog(t *testing.T) {
+	mux := http.NewServeMux()
+	serveJSON(mux, "/repos/kelda/bot/compare/v1...v2", `{"commits": [
+		{"sha": "a", "commit": {"message": "Add a feature (#1)"}},
+		{"sha": "b", "commit": {"message": "Merge pull request #2 from x"}},
+		{"sha": "c", "commit": {"message": "Fix a bug (#3)"}}]}`)
+	serveJSON(mux, "/repos/kelda/bot/commits/v1",
+		`{"commit": {"committer": {"date": "2026-10-01T00:00:00Z"}}}`)
+	serveJSON(mux, "/repos/kelda/bot/issues/1", `{"number": 1,
+		"title": "Add a feature", "user": {"login": "ann"},
+		"labels": [{"name": "feature"}]}`)
+	serveJSON(mux, "/repos/kelda/bot/issues/2", `{"number": 2,
+		"title": "Document it", "user": {"login": "bob"},
+		"labels": [{"name": "docs"}]}`)
+	serveJSON(mux, "/repos/kelda/bot/issues/3", `{"number": 3,
+		"title": "Fix a bug", "user": {"login": "ann"}}`)
+	mux.HandleFunc("/search/issues", func(w http.ResponseWriter, r *http.Request) {
+		// ann has contributed before, and the check for bob fails.
+		if strings.Contains(r.URL.Query().Get("q"), "author:bob") {
+			http.Error(w, `{"message": "rate limited"}`, http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"total_count": 4}`))
+	})
+	client, closeGitHub := newFakeGitHub(mux)
+	defer closeGitHub()
+
+	got, err := generateChangelog(client, "bot", "v1", "v2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := strings.Join([]string{
+		"## Features",
+		"",
+		"- Add a feature (#1) by @ann",
+		"",
+		"## Documentation",
+		"",
+		"- Document it (#2) by @bob",
+		"",
+		"## Other Changes",
+		"",
+		"- Fix a bug (#3) by @ann",
+		"",
+		"## First-Time Contributors",
+		"",
+		"I couldn't check whether these people are new: @bob.",
+	}, "\n")
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
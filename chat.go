@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// runChatBot connects to Slack's real time messaging API, and answers the
+// messages that mention the bot or are sent to it directly. It never returns;
+// the RTM client reconnects with backoff when the connection drops.
+func runChatBot(githubClient *github.Client, googleClient *sheets.Service,
+	slackClient *slack.Client) {
+	rtm := slackClient.NewRTM()
+	go rtm.ManageConnection()
+
+	botID := ""
+	for event := range rtm.IncomingEvents {
+		switch ev := event.Data.(type) {
+		case *slack.ConnectedEvent:
+			if ev.Info != nil && ev.Info.User != nil {
+				botID = ev.Info.User.ID
+			}
+			log.Infof("Connected to Slack RTM (connection %d)",
+				ev.ConnectionCount)
+		case *slack.MessageEvent:
+			if botID == "" || ev.User == "" || ev.User == botID ||
+				ev.SubType != "" {
+				continue
+			}
+			text, ok := chatRequestText(ev, botID)
+			if !ok {
+				continue
+			}
+			reply := handleChatMessage(githubClient, googleClient,
+				ev.User, text)
+			rtm.SendMessage(rtm.NewOutgoingMessage(reply, ev.Channel))
+		case *slack.InvalidAuthEvent:
+			log.Warn("Slack rejected the bot's credentials; " +
+				"not answering messages")
+			return
+		}
+	}
+}
+
+// chatRequestText returns the text of the given message addressed to the bot,
+// without the mention, and whether the message was addressed to the bot at
+// all. Every direct message is; other messages must mention the bot.
+func chatRequestText(ev *slack.MessageEvent, botID string) (string, bool) {
+	mention := fmt.Sprintf("<@%s>", botID)
+	switch {
+	case strings.Contains(ev.Text, mention):
+		return strings.Replace(ev.Text, mention, "", -1), true
+	case strings.HasPrefix(ev.Channel, "D"):
+		return ev.Text, true
+	}
+	return "", false
+}
+
+// handleChatMessage runs the command in a message sent to the bot by the given
+// Slack user, and returns the reply.
+func handleChatMessage(githubClient *github.Client, googleClient *sheets.Service,
+	slackID, text string) string {
+	login, ok := loginForSlackUser(slackID)
+	if !ok {
+		return "I don't know your GitHub login. Ask an admin to add your " +
+			"Slack ID to the bot's config file."
+	}
+
+	args := strings.Fields(text)
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "stats":
+			reply, err := latestStats(googleClient)
+			if err != nil {
+				log.WithError(err).Warn("unable to read the latest stats")
+				return fmt.Sprintf("Error: %s", err)
+			}
+			return reply
+		case "presented":
+			reply, err := logPresentation(googleClient, login, text)
+			if err != nil {
+				return fmt.Sprintf("Error: %s", err)
+			}
+			return reply
+		case "help":
+			return helpText() + "\nIn Slack, you can also ask me for " +
+				"the latest `stats`, or tell me you `presented 40 " +
+				"people at EVENT on YYYY-MM-DD`."
+		}
+	}
+	return runCommand(githubClient, commandRequest{login: login, args: args})
+}
+
+// reviewsCommand handles "reviews", which lists the pull requests waiting for a
+// review from the person who issued the command.
+func reviewsCommand(client *github.Client, req commandRequest) (string, error) {
+	now := time.Now()
+	var lines []string
+	for _, summary := range getReviewSnapshot() {
+		if since, ok := summary.waiting[req.login]; ok {
+			lines = append(lines, fmt.Sprintf("• %s (requested %s ago)",
+				prLink(summary.pr), now.Sub(since).Round(time.Minute)))
+		}
+	}
+	if len(lines) == 0 {
+		return "Nothing is waiting for your review.", nil
+	}
+	return "Waiting for your review:\n" + strings.Join(lines, "\n"), nil
+}
+
+// prCommand handles "pr REPO#NUMBER", which describes the state of a pull
+// request and its reviewers.
+func prCommand(client *github.Client, req commandRequest) (string, error) {
+	if len(req.args) != 2 {
+		return "", errors.New("usage: pr REPO#NUMBER")
+	}
+	repo, number, err := parsePRKey(req.args[1])
+	if err != nil {
+		return "", err
+	}
+	pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo, number)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get %s: %s", req.args[1], err)
+	}
+
+	state := pr.GetState()
+	switch {
+	case pr.GetMerged():
+		state = "merged"
+	case state == "open":
+		for _, summary := range getReviewSnapshot() {
+			if prKey(summary.pr) == prKey(pr) && summary.stage != "" {
+				state = summary.stage
+			}
+		}
+	}
+	lines := []string{fmt.Sprintf("%s by %s is %s.", prLink(pr),
+		pr.User.GetLogin(), state)}
+
+	requested, err := getRequestedReviewers(client, pr)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the requested reviewers: %s", err)
+	}
+	if len(requested) > 0 {
+		var logins []string
+		for _, r := range requested {
+			logins = append(logins, r.GetLogin())
+		}
+		lines = append(lines, fmt.Sprintf("Waiting for: %s.",
+			strings.Join(logins, ", ")))
+	}
+
+	reviews, err := getReviews(client, pr)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the reviews: %s", err)
+	}
+	var order []string
+	latest := map[string]string{}
+	for _, r := range reviews {
+		login := r.User.GetLogin()
+		if _, ok := latest[login]; !ok {
+			order = append(order, login)
+		}
+		if r.State != "COMMENTED" || latest[login] == "" {
+			latest[login] = r.State
+		}
+	}
+	if len(order) > 0 {
+		var verdicts []string
+		for _, login := range order {
+			verdicts = append(verdicts, fmt.Sprintf("%s %s", login,
+				strings.ToLower(strings.Replace(latest[login], "_", " ", -1))))
+		}
+		lines = append(lines, fmt.Sprintf("Reviews: %s.",
+			strings.Join(verdicts, ", ")))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// latestStats returns the most recent row of the metrics summary sheet, with
+// each value labeled by its column header.
+func latestStats(googleClient *sheets.Service) (string, error) {
+	readRange := fmt.Sprintf("%s!A1:L", summarySheetName)
+	resp, err := googleClient.Spreadsheets.Values.Get(
+		googleSpreadsheetID, readRange).Do()
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Values) < 2 {
+		return "", errors.New("there are no stats yet")
+	}
+
+	header := resp.Values[0]
+	latest := resp.Values[len(resp.Values)-1]
+	var lines []string
+	for i, value := range latest {
+		label := fmt.Sprintf("Column %d", i+1)
+		if i < len(header) && fmt.Sprint(header[i]) != "" {
+			label = fmt.Sprint(header[i])
+		}
+		lines = append(lines, fmt.Sprintf("*%s:* %v", label, value))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// ciFailure records the notification sent to a PR author about failing checks
+// on one commit, so that later results for the commit go in the same thread.
+type ciFailure struct {
+	PR     string `json:"pr"`
+	Author string `json:"author"`
+
+	// Channel and Timestamp identify the Slack message the author was
+	// sent. They're empty until the message is sent, if it was queued for
+	// Do Not Disturb.
+	Channel   string `json:"channel,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+
+	// Failing maps the context of each check that's currently failing to
+	// the URL with its details.
+	Failing map[string]string `json:"failing"`
+}
+
+// checkRunEvent is the part of a check_run webhook event that the bot uses.
+// The vendored GitHub client predates the checks API, so we decode it
+// ourselves.
+type checkRunEvent struct {
+	Action   string `json:"action"`
+	CheckRun struct {
+		Name       string `json:"name"`
+		HeadSHA    string `json:"head_sha"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+		Output     struct {
+			Title string `json:"title"`
+		} `json:"output"`
+	} `json:"check_run"`
+	Repo github.Repository `json:"repository"`
+}
+
+// handleCheckRun reports the result of a completed check run like a status.
+func handleCheckRun(client *github.Client, slackClient *slack.Client,
+	payload []byte) {
+	var event checkRunEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.WithError(err).Warn("unable to parse check_run event")
+		return
+	}
+	if event.Action != "completed" {
+		return
+	}
+
+	run := event.CheckRun
+	var state string
+	switch run.Conclusion {
+	case "success", "neutral", "skipped":
+		state = "success"
+	case "failure", "timed_out", "action_required":
+		state = "failure"
+	default:
+		return
+	}
+	handleCIResult(client, slackClient, event.Repo.GetName(), run.HeadSHA,
+		run.Name, state, run.Output.Title, run.HTMLURL)
+}
+
+// handleCIResult tells the authors of the open pull requests whose head is sha
+// when a check on it fails. Authors are sent one message per commit; later
+// failures and recoveries on the same commit are added to its thread.
+func handleCIResult(client *github.Client, slackClient *slack.Client,
+	repo, sha, context, state, description, targetURL string) {
+	switch state {
+	case "failure", "error":
+		keys := ciFailureKeys(sha)
+		if len(keys) == 0 {
+			for _, pr := range openPRsForCommit(client, repo, sha) {
+				if !notifyCIFailure(slackClient, pr, context,
+					description, targetURL) {
+					// Another failing check on the commit got
+					// there first.
+					keys = append(keys, ciFailureKey(sha, prKey(pr)))
+				}
+			}
+		}
+		for _, key := range keys {
+			addFailingCheck(slackClient, key, context, description,
+				targetURL)
+		}
+	case "success":
+		for _, key := range ciFailureKeys(sha) {
+			failure, remaining, recovered := recordCIResult(key,
+				context, false, "")
+			if !recovered {
+				continue
+			}
+			summary := fmt.Sprintf(":white_check_mark: *%s* is passing now.",
+				context)
+			if len(remaining) == 0 {
+				summary += " All of the failed checks have recovered."
+			} else {
+				summary += fmt.Sprintf(" Still failing: %s.",
+					strings.Join(remaining, ", "))
+			}
+			replyAboutCI(slackClient, key, failure, summary)
+			postToPRThread(slackClient, failure.PR, summary)
+		}
+	}
+}
+
+// ciFailureKey returns the key in botState.CIFailures of the notification
+// about failing checks on sha, the head of the pull request with the given
+// prKey.
+func ciFailureKey(sha, pr string) string {
+	return sha + " " + pr
+}
+
+// ciFailureKeys returns the keys of the CI failure notifications about sha.
+func ciFailureKeys(sha string) []string {
+	var keys []string
+	viewState(func(s *botState) {
+		for key := range s.CIFailures {
+			if strings.HasPrefix(key, sha+" ") {
+				keys = append(keys, key)
+			}
+		}
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// notifyCIFailure tells the author of the given pull request that a check on
+// its head failed, unless they've already been told about a failure on it. It
+// returns whether it sent the notification.
+func notifyCIFailure(slackClient *slack.Client, pr *github.PullRequest,
+	context, description, targetURL string) bool {
+	key := ciFailureKey(pr.Head.GetSHA(), prKey(pr))
+	author := *pr.User.Login
+
+	// Claim the notification before sending it, so that checks failing
+	// at the same time don't each send one.
+	claimed := false
+	updateState(func(s *botState) {
+		if _, ok := s.CIFailures[key]; ok {
+			return
+		}
+		if s.CIFailures == nil {
+			s.CIFailures = map[string]ciFailure{}
+		}
+		s.CIFailures[key] = ciFailure{
+			PR:      prKey(pr),
+			Author:  author,
+			Failing: map[string]string{context: targetURL},
+		}
+		claimed = true
+	})
+	if !claimed {
+		return false
+	}
+
+	channel, timestamp := sendNotification(slackClient, queuedNotification{
+		Login: author,
+		Text: fmt.Sprintf(":x: The checks on %s failed.\n*%s*: %s %s",
+			prLink(pr), context, description, targetURL),
+		CIFailure: key,
+	})
+	postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+		":x: *%s* failed: %s %s", context, description, targetURL))
+	if channel != "" {
+		recordCIThread(key, channel, timestamp)
+	}
+	return true
+}
+
+// recordCIThread records the Slack message that was sent for the CI failure
+// notification with the given key.
+func recordCIThread(key, channel, timestamp string) {
+	updateState(func(s *botState) {
+		if failure, ok := s.CIFailures[key]; ok {
+			failure.Channel = channel
+			failure.Timestamp = timestamp
+			s.CIFailures[key] = failure
+		}
+	})
+}
+
+// addFailingCheck tells the author about another check failing on a commit
+// they've already been notified about, unless it was already known to fail.
+func addFailingCheck(slackClient *slack.Client, key, context, description,
+	targetURL string) {
+	failure, _, added := recordCIResult(key, context, true, targetURL)
+	if !added {
+		return
+	}
+	text := fmt.Sprintf(":x: *%s* is failing too: %s %s", context,
+		description, targetURL)
+	replyAboutCI(slackClient, key, failure, text)
+	postToPRThread(slackClient, failure.PR, text)
+}
+
+// recordCIResult records whether the given check is failing in the CI failure
+// notification with the given key. It returns the notification, the checks
+// that are still failing, and whether the check's result changed.
+func recordCIResult(key, context string, failing bool, targetURL string) (
+	ciFailure, []string, bool) {
+	var failure ciFailure
+	var remaining []string
+	changed := false
+	updateState(func(s *botState) {
+		var ok bool
+		failure, ok = s.CIFailures[key]
+		if !ok {
+			// The PR was closed in the meantime.
+			return
+		}
+		_, wasFailing := failure.Failing[context]
+		changed = wasFailing != failing
+		if failing {
+			failure.Failing[context] = targetURL
+		} else {
+			delete(failure.Failing, context)
+		}
+		for c := range failure.Failing {
+			remaining = append(remaining, c)
+		}
+	})
+	sort.Strings(remaining)
+	return failure, remaining, changed
+}
+
+// replyAboutCI adds a message to the thread of the CI failure notification
+// with the given key. If the notification is still queued, the message is
+// added to it instead, and if it was never sent, the message is sent on its
+// own.
+func replyAboutCI(slackClient *slack.Client, key string, failure ciFailure,
+	text string) {
+	queued := false
+	updateState(func(s *botState) {
+		// The notification may have been sent since failure was read.
+		if current, ok := s.CIFailures[key]; ok && current.Channel != "" {
+			failure = current
+			return
+		}
+		for i, n := range s.Notifications {
+			if n.CIFailure == key {
+				s.Notifications[i].Text += "\n" + text
+				queued = true
+				return
+			}
+		}
+	})
+	switch {
+	case queued:
+	case failure.Channel == "":
+		notifyUser(slackClient, failure.Author, fmt.Sprintf("%s: %s",
+			failure.PR, text))
+	default:
+		postThreadReply(slackClient, failure.Channel, failure.Timestamp,
+			text)
+	}
+}
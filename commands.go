@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// commandPrefix starts every bot command in a GitHub comment.
+const commandPrefix = "/bot"
+
+// backportPrefix is a shorthand for "/bot backport" in GitHub comments.
+const backportPrefix = "/backport"
+
+// commandRequest is a bot command issued either in Slack or in a comment on a
+// pull request.
+type commandRequest struct {
+	// login is the GitHub login of the person who issued the command.
+	login string
+
+	// args are the words of the command, starting with its name.
+	args []string
+
+	// pr is the pull request the command was issued on, or nil if the
+	// command came from Slack.
+	pr *github.PullRequest
+}
+
+// A commandHandler runs a command and returns the reply to send to the person
+// who issued it.
+type commandHandler func(client *github.Client, req commandRequest) (string, error)
+
+// commandHandlers maps command names to their handlers. It's populated in
+// init to avoid an initialization loop through helpCommand.
+var commandHandlers map[string]commandHandler
+
+func init() {
+	commandHandlers = map[string]commandHandler{
+		"away":      awayCommand,
+		"back":      backCommand,
+		"backport":  backportCommand,
+		"changelog": changelogCommand,
+		"help":      helpCommand,
+		"pr":        prCommand,
+		"queue":     queueCommand,
+		"reviews":   reviewsCommand,
+	}
+}
+
+// runCommand runs the given command, and returns the reply to send to the
+// person who issued it.
+func runCommand(client *github.Client, req commandRequest) string {
+	if len(req.args) == 0 {
+		return helpText()
+	}
+
+	handler, ok := commandHandlers[strings.ToLower(req.args[0])]
+	if !ok {
+		return fmt.Sprintf("Unknown command %q.\n%s", req.args[0], helpText())
+	}
+
+	reply, err := handler(client, req)
+	if err != nil {
+		log.WithError(err).Infof("command %q from %s failed",
+			strings.Join(req.args, " "), req.login)
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return reply
+}
+
+func helpCommand(client *github.Client, req commandRequest) (string, error) {
+	return helpText(), nil
+}
+
+func helpText() string {
+	var names []string
+	for name := range commandHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("Available commands: %s", strings.Join(names, ", "))
+}
+
+// handleIssueComment runs any bot commands in a newly created comment on a pull
+// request, and replies to them with a comment.
+func handleIssueComment(client *github.Client, event *github.IssueCommentEvent) {
+	if event.GetAction() != "created" || event.Issue.PullRequestLinks == nil {
+		return
+	}
+
+	var commands [][]string
+	for _, line := range strings.Split(event.Comment.GetBody(), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == commandPrefix:
+			commands = append(commands, fields[1:])
+		case fields[0] == backportPrefix:
+			commands = append(commands,
+				append([]string{"backport"}, fields[1:]...))
+		}
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx(), "kelda",
+		event.Repo.GetName(), event.Issue.GetNumber())
+	if err != nil {
+		log.WithError(err).Warnf("unable to get PR %d", event.Issue.GetNumber())
+		return
+	}
+
+	var replies []string
+	for _, args := range commands {
+		replies = append(replies, runCommand(client, commandRequest{
+			login: event.Comment.User.GetLogin(),
+			args:  args,
+			pr:    pr,
+		}))
+	}
+
+	body := fmt.Sprintf("@%s %s", event.Comment.User.GetLogin(),
+		strings.Join(replies, "\n\n"))
+	_, _, err = client.Issues.CreateComment(ctx(), "kelda",
+		event.Repo.GetName(), event.Issue.GetNumber(),
+		&github.IssueComment{Body: &body})
+	if err != nil {
+		log.WithError(err).Warnf("unable to reply to command on PR %d",
+			event.Issue.GetNumber())
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+var (
+	// cachedBotLogin is the GitHub login that the bot acts as. It never
+	// changes while the bot is running.
+	cachedBotLogin     string
+	cachedBotLoginLock sync.Mutex
+)
+
+// botLogin returns the GitHub login that the bot acts as.
+func botLogin(client *github.Client) (string, error) {
+	cachedBotLoginLock.Lock()
+	defer cachedBotLoginLock.Unlock()
+	if cachedBotLogin != "" {
+		return cachedBotLogin, nil
+	}
+
+	user, _, err := client.Users.Get(ctx(), "")
+	if err != nil {
+		return "", err
+	}
+	cachedBotLogin = user.GetLogin()
+	return cachedBotLogin, nil
+}
+
+// isBot returns whether the given login is the bot's own. If the bot's login
+// can't be fetched, nobody is treated as the bot.
+func isBot(client *github.Client, login string) bool {
+	bot, err := botLogin(client)
+	if err != nil {
+		log.WithError(err).Warn("unable to get the bot's login")
+		return false
+	}
+	return login == bot
+}
+
+// commentOnPR posts a comment on the given pull request.
+func commentOnPR(client *github.Client, pr *github.PullRequest, body string) {
+	_, _, err := client.Issues.CreateComment(ctx(), "kelda",
+		*pr.Base.Repo.Name, *pr.Number, &github.IssueComment{Body: &body})
+	if err != nil {
+		log.WithError(err).Warnf("unable to comment on %s", prKey(pr))
+	}
+}
+
+// findMarkedComment returns the bot's comment on the given pull request that
+// contains marker, or nil if there isn't one. Markers are HTML comments that the
+// bot puts in comments it edits over time. Comments by anyone else are ignored,
+// even if they quote the marker.
+func findMarkedComment(client *github.Client, pr *github.PullRequest,
+	marker string) (*github.IssueComment, error) {
+	login, err := botLogin(client)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &github.IssueListCommentsOptions{}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx(), "kelda",
+			*pr.Base.Repo.Name, *pr.Number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			if comment.User.GetLogin() == login &&
+				strings.Contains(comment.GetBody(), marker) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// setMarkedComment makes the comment on the given pull request that contains
+// marker say body, creating it if it doesn't exist yet. If create is false, a
+// missing comment isn't created. It returns the comment's URL, if there is one.
+func setMarkedComment(client *github.Client, pr *github.PullRequest, marker,
+	body string, create bool) string {
+	existing, err := findMarkedComment(client, pr, marker)
+	if err != nil {
+		log.WithError(err).Warnf("unable to list the comments on %s",
+			prKey(pr))
+		return ""
+	}
+
+	body = marker + "\n" + body
+	repo := *pr.Base.Repo.Name
+	switch {
+	case existing != nil && existing.GetBody() == body:
+		return existing.GetHTMLURL()
+	case existing != nil:
+		comment, _, err := client.Issues.EditComment(ctx(), "kelda", repo,
+			existing.GetID(), &github.IssueComment{Body: &body})
+		if err != nil {
+			log.WithError(err).Warnf("unable to edit comment on %s",
+				prKey(pr))
+			return existing.GetHTMLURL()
+		}
+		return comment.GetHTMLURL()
+	case create:
+		comment, _, err := client.Issues.CreateComment(ctx(), "kelda", repo,
+			*pr.Number, &github.IssueComment{Body: &body})
+		if err != nil {
+			log.WithError(err).Warnf("unable to comment on %s", prKey(pr))
+			return ""
+		}
+		return comment.GetHTMLURL()
+	}
+	return ""
+}
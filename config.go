@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// botConfig is the configuration read from the config file at startup.
+type botConfig struct {
+	// Users holds per-person settings, keyed by GitHub login.
+	Users map[string]userConfig `json:"users"`
+
+	// DefaultMaxReviews is the maximum number of open review requests
+	// that a person can hold before the bot stops assigning them new
+	// reviews. Zero means there is no limit.
+	DefaultMaxReviews int `json:"defaultMaxReviews"`
+
+	// DefaultTimezone is the IANA timezone (e.g., "America/Los_Angeles")
+	// of people whose timezone isn't set in the config or on Slack.
+	DefaultTimezone string `json:"defaultTimezone"`
+
+	// WorkdayStart and WorkdayEnd are the hours, in each person's own
+	// timezone, between which the bot counts working time and sends
+	// reminders. They default to 9 and 17.
+	WorkdayStart int `json:"workdayStart"`
+	WorkdayEnd   int `json:"workdayEnd"`
+
+	// HolidayCalendar is the path to an ICS file whose events are days
+	// off for everyone.
+	HolidayCalendar string `json:"holidayCalendar"`
+
+	// ReminderHours is how many working hours a requested reviewer
+	// has to act on a review before they're reminded about it. Zero
+	// disables reminders.
+	ReminderHours int `json:"reminderHours"`
+
+	// MergeChannel is the Slack channel in which pull requests that are
+	// ready to merge are announced.
+	MergeChannel string `json:"mergeChannel"`
+
+	// HealthReportChannel is the Slack channel that the weekly review
+	// health report is posted in.
+	HealthReportChannel string `json:"healthReportChannel"`
+
+	// ReleaseChannel is the Slack channel that new releases are announced
+	// in.
+	ReleaseChannel string `json:"releaseChannel"`
+
+	// WelcomeMessage is the comment posted on the first pull request or
+	// issue of a new contributor. "{login}" is replaced with their GitHub
+	// login, and "{guide}" with ContributingGuide.
+	WelcomeMessage string `json:"welcomeMessage"`
+
+	// ContributingGuide is the URL of the contributing guide.
+	ContributingGuide string `json:"contributingGuide"`
+
+	// Repos holds per-repository settings, keyed by repository name.
+	Repos map[string]repoConfig `json:"repos"`
+
+	// BranchCleanup configures the deletion of branches that are no
+	// longer needed.
+	BranchCleanup branchCleanupConfig `json:"branchCleanup"`
+
+	// Triage configures the triage of issues opened by people outside the
+	// team. Issues aren't triaged if it's unset.
+	Triage *triageConfig `json:"triage"`
+
+	// Stale configures the daily sweep for inactive issues and pull
+	// requests.
+	Stale staleConfig `json:"stale"`
+}
+
+// staleConfig configures the marking and closing of inactive issues and pull
+// requests.
+type staleConfig struct {
+	staleThresholds
+
+	// ExemptLabels are labels whose issues and pull requests are never
+	// marked stale.
+	ExemptLabels []string `json:"exemptLabels"`
+
+	// DryRun makes the sweep only report what it would mark stale and
+	// close, rather than doing it.
+	DryRun bool `json:"dryRun"`
+
+	// ReportChannel is the Slack channel the sweep reports to.
+	ReportChannel string `json:"reportChannel"`
+}
+
+// staleThresholds are the inactivity periods after which issues and pull
+// requests are marked stale and closed.
+type staleThresholds struct {
+	// Days is how long an issue or pull request can go without activity
+	// before it's marked stale. Zero disables the sweep.
+	Days int `json:"days"`
+
+	// CloseDays is how long an issue or pull request can stay stale
+	// before it's closed. Zero means stale items are never closed.
+	CloseDays int `json:"closeDays"`
+}
+
+// triageConfig configures the issue triage rotation.
+type triageConfig struct {
+	// Rotation lists the GitHub logins of the people who triage issues,
+	// in order. If it's empty, everyone on the team does.
+	Rotation []string `json:"rotation"`
+
+	// Channel is the Slack channel that new issues are posted to.
+	Channel string `json:"channel"`
+
+	// SLAHours is how many of their working hours the triager has to
+	// respond to an issue before they're reminded. Reminders are repeated
+	// every SLAHours working hours until someone on the team comments.
+	// Zero disables reminders.
+	SLAHours int `json:"slaHours"`
+
+	// Labels are added to issues whose title or body mention their
+	// keywords.
+	Labels []labelRule `json:"labels"`
+}
+
+// labelRule adds a label to issues that mention any of its keywords, ignoring
+// case.
+type labelRule struct {
+	Label    string   `json:"label"`
+	Keywords []string `json:"keywords"`
+}
+
+// branchCleanupConfig configures the deletion of merged and stale branches.
+// The head branch of a merged pull request is always deleted, unless it's
+// protected or excluded.
+type branchCleanupConfig struct {
+	// Exclude lists patterns, in the syntax of path.Match, of branches
+	// that are never deleted (e.g., "release-*").
+	Exclude []string `json:"exclude"`
+
+	// StaleDays is how long a branch without an open pull request can go
+	// without commits before it's considered stale. Zero disables the
+	// daily sweep for stale branches.
+	StaleDays int `json:"staleDays"`
+
+	// DeleteStale makes the sweep delete the stale branches it finds,
+	// rather than only reporting them.
+	DeleteStale bool `json:"deleteStale"`
+
+	// ReportChannel is the Slack channel the sweep reports to.
+	ReportChannel string `json:"reportChannel"`
+}
+
+// repoConfig holds the settings for a single repository.
+type repoConfig struct {
+	// AutoMerge makes the bot merge pull requests once a committer has
+	// approved them and their status checks pass.
+	AutoMerge bool `json:"autoMerge"`
+
+	// MergeMethod is how the bot merges pull requests: "merge",
+	// "squash" or "rebase". It defaults to "merge".
+	MergeMethod string `json:"mergeMethod"`
+
+	// MergeQueue makes approved pull requests go through a merge queue,
+	// where they're merged one at a time after being updated with their
+	// base branch and passing their status checks. It takes precedence
+	// over AutoMerge.
+	MergeQueue bool `json:"mergeQueue"`
+
+	// SlackChannel is where the bot follows the repository's pull
+	// requests, with a message per pull request and its events in the
+	// message's thread.
+	SlackChannel string `json:"slackChannel"`
+
+	// Description enables checking the descriptions of the repository's
+	// pull requests.
+	Description *descriptionConfig `json:"description"`
+
+	// Stale overrides the default thresholds for marking the
+	// repository's issues and pull requests stale.
+	Stale *staleThresholds `json:"stale"`
+}
+
+// descriptionConfig holds the requirements for pull request descriptions.
+type descriptionConfig struct {
+	// Sections are the headings in the body that must have something
+	// under them. If it's empty, every heading in the repository's pull
+	// request template is required.
+	Sections []string `json:"sections"`
+
+	// RequireIssue requires the body to link to an issue.
+	RequireIssue bool `json:"requireIssue"`
+
+	// TitlePattern is a regular expression that titles must match.
+	TitlePattern string `json:"titlePattern"`
+
+	// HoldReview stops reviewers from being assigned until the
+	// description passes.
+	HoldReview bool `json:"holdReview"`
+}
+
+// userConfig holds the settings for a single team member.
+type userConfig struct {
+	// Slack is the person's Slack user ID (e.g., "U024BE7LH").
+	Slack string `json:"slack"`
+
+	// Timezone is the person's IANA timezone. If it's not set, the
+	// timezone from their Slack profile is used.
+	Timezone string `json:"timezone"`
+
+	// MaxReviews overrides DefaultMaxReviews for this person.
+	MaxReviews int `json:"maxReviews"`
+
+	// Away lists the periods during which the person shouldn't be
+	// assigned reviews.
+	Away []awayPeriod `json:"away"`
+
+	// Mentor marks the person as a preferred reviewer for pull requests
+	// from first-time contributors.
+	Mentor bool `json:"mentor"`
+}
+
+// config is the bot's configuration. It's empty if there is no config file.
+var config botConfig
+
+// loadConfig reads the config file at path. A missing config file isn't an
+// error, since all of the settings have defaults.
+func loadConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &config)
+}
+
+// maxReviews returns the maximum number of open review requests the given
+// user can hold, or zero if there's no limit.
+func maxReviews(login string) int {
+	if n := config.Users[login].MaxReviews; n != 0 {
+		return n
+	}
+	return config.DefaultMaxReviews
+}
+
+// loginForSlackUser returns the GitHub login configured for the given Slack
+// user ID, and whether one was found.
+func loginForSlackUser(slackID string) (string, bool) {
+	for login, user := range config.Users {
+		if user.Slack == slackID {
+			return login, true
+		}
+	}
+	return "", false
+}
+
+// dateFormat is the format for dates in the config file, the state file, and
+// bot commands.
+const dateFormat = "2006-01-02"
+
+// date is a calendar day. It's written as YYYY-MM-DD in JSON.
+type date struct {
+	time.Time
+}
+
+func parseDate(s string) (date, error) {
+	t, err := time.Parse(dateFormat, s)
+	return date{t}, err
+}
+
+func (d date) String() string {
+	return d.Format(dateFormat)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := parseDate(s)
+	*d = parsed
+	return err
+}
+
+// awayPeriod is a range of days, including both the first and last day,
+// during which someone is unavailable.
+type awayPeriod struct {
+	Start date `json:"start"`
+	End   date `json:"end"`
+}
+
+// contains returns whether t falls within the period.
+func (p awayPeriod) contains(t time.Time) bool {
+	return !t.Before(p.Start.Time) && t.Before(p.End.AddDate(0, 0, 1))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// needsRebaseLabel is added to pull requests that conflict with their base
+// branch.
+const needsRebaseLabel = "needs-rebase"
+
+// GitHub computes whether a pull request can be merged in the background, so
+// after a push we wait before checking, and retry if it isn't known yet.
+const (
+	mergeabilityDelay    = 30 * time.Second
+	mergeabilityAttempts = 5
+)
+
+// handlePush rechecks whether each open pull request targeting the pushed
+// branch can still be merged.
+func handlePush(client *github.Client, slackClient *slack.Client,
+	event *github.PushEvent) {
+	if !strings.HasPrefix(event.GetRef(), "refs/heads/") || event.GetDeleted() {
+		return
+	}
+	repo := event.Repo.GetName()
+	branch := strings.TrimPrefix(event.GetRef(), "refs/heads/")
+
+	go func() {
+		time.Sleep(mergeabilityDelay)
+		opts := &github.PullRequestListOptions{Base: branch}
+		for {
+			prs, resp, err := client.PullRequests.List(ctx(), "kelda", repo, opts)
+			if err != nil {
+				log.WithError(err).Warnf("unable to list PRs against %s "+
+					"of %s", branch, repo)
+				return
+			}
+			// Check the PRs in parallel, so that one that GitHub
+			// is slow to work out doesn't hold up the rest.
+			for _, pr := range prs {
+				go checkMergeable(client, slackClient, repo, *pr.Number)
+			}
+			if resp.NextPage == 0 {
+				return
+			}
+			opts.Page = resp.NextPage
+		}
+	}()
+}
+
+// checkMergeableLater checks whether the given pull request can be merged,
+// after giving GitHub time to work it out.
+func checkMergeableLater(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest) {
+	go func() {
+		time.Sleep(mergeabilityDelay)
+		checkMergeable(client, slackClient, *pr.Base.Repo.Name, *pr.Number)
+	}()
+}
+
+// checkMergeable fetches whether the given pull request can be merged, and
+// updates its label and the bot's state to match.
+func checkMergeable(client *github.Client, slackClient *slack.Client,
+	repo string, number int) {
+	for i := 0; i < mergeabilityAttempts; i++ {
+		pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo, number)
+		if err != nil {
+			log.WithError(err).Warnf("unable to get PR %s#%d", repo, number)
+			return
+		}
+		if pr.GetState() != "open" {
+			return
+		}
+		if pr.Mergeable != nil {
+			setConflicted(client, slackClient, pr, !pr.GetMergeable())
+			return
+		}
+		time.Sleep(mergeabilityDelay)
+	}
+	log.Warnf("GitHub never worked out whether %s#%d can be merged",
+		repo, number)
+}
+
+// setConflicted records whether the given pull request conflicts with its
+// base branch. When a conflict starts, the PR is labeled and its author is
+// told about it once; when it ends, the label is removed.
+func setConflicted(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest, conflicted bool) {
+	key := prKey(pr)
+	changed := false
+	updateState(func(s *botState) {
+		// Several webhooks can find the same conflict at once, so
+		// check and record it together to only act on it once.
+		if s.Conflicts[key] == conflicted {
+			return
+		}
+		changed = true
+		if s.Conflicts == nil {
+			s.Conflicts = map[string]bool{}
+		}
+		if conflicted {
+			s.Conflicts[key] = true
+		} else {
+			delete(s.Conflicts, key)
+		}
+	})
+	if !changed {
+		return
+	}
+
+	repo := *pr.Base.Repo.Name
+	if !conflicted {
+		log.Infof("%s no longer has merge conflicts", key)
+		_, err := client.Issues.RemoveLabelForIssue(ctx(), "kelda", repo,
+			*pr.Number, needsRebaseLabel)
+		if err != nil {
+			log.WithError(err).Warnf("unable to unlabel %s", key)
+		}
+		return
+	}
+
+	log.Infof("%s has merge conflicts", key)
+	_, _, err := client.Issues.AddLabelsToIssue(ctx(), "kelda", repo,
+		*pr.Number, []string{needsRebaseLabel})
+	if err != nil {
+		log.WithError(err).Warnf("unable to label %s", key)
+	}
+	author := *pr.User.Login
+	commentOnPR(client, pr, fmt.Sprintf("@%s this pull request now conflicts "+
+		"with `%s`. Please rebase it to resolve the conflicts.",
+		author, pr.Base.GetRef()))
+	notifyUser(slackClient, author, fmt.Sprintf(
+		"%s now conflicts with `%s`, and needs a rebase.",
+		prLink(pr), pr.Base.GetRef()))
+}
+
+// hasMergeConflict returns whether the given pull request was last found to
+// conflict with its base branch.
+func hasMergeConflict(pr *github.PullRequest) bool {
+	conflicted := false
+	viewState(func(s *botState) {
+		conflicted = s.Conflicts[prKey(pr)]
+	})
+	return conflicted
+}
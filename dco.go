@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+const (
+	// dcoContext is the context of the commit status reporting whether a
+	// pull request's commits are signed off.
+	dcoContext = "kelda-bot/dco"
+
+	// dcoMarker identifies the bot's comment about missing sign-offs.
+	dcoMarker = "<!-- kelda-bot:dco -->"
+)
+
+// checkDCO checks that every commit in the given pull request, other than merge
+// commits, is signed off by its author, as the Developer Certificate of Origin
+// requires, and reports the result as a commit status on the PR's head. When
+// the check fails, the bot comments with the offending commits; the comment is
+// updated rather than duplicated by later pushes.
+func checkDCO(client *github.Client, pr *github.PullRequest) {
+	commits, err := listPRCommits(client, pr)
+	if err != nil {
+		log.WithError(err).Warnf("unable to list the commits of %s", prKey(pr))
+		return
+	}
+
+	unsigned := unsignedCommits(commits)
+
+	status := &github.RepoStatus{Context: github.String(dcoContext)}
+	if len(unsigned) == 0 {
+		status.State = github.String("success")
+		status.Description = github.String("All commits are signed off")
+		setMarkedComment(client, pr, dcoMarker,
+			"All of the commits are signed off now. Thanks!", false)
+	} else {
+		status.State = github.String("failure")
+		status.Description = github.String(fmt.Sprintf(
+			"%d of %d commits aren't signed off by their author",
+			len(unsigned), len(commits)))
+		url := setMarkedComment(client, pr, dcoMarker,
+			dcoInstructions(pr, unsigned), true)
+		if url != "" {
+			status.TargetURL = &url
+		}
+	}
+
+	_, _, err = client.Repositories.CreateStatus(ctx(), "kelda",
+		*pr.Base.Repo.Name, pr.Head.GetSHA(), status)
+	if err != nil {
+		log.WithError(err).Warnf("unable to set the DCO status of %s",
+			prKey(pr))
+	}
+}
+
+// unsignedCommits returns the SHAs of the given commits, other than merge
+// commits, that aren't signed off by their author.
+func unsignedCommits(commits []*github.RepositoryCommit) []string {
+	var unsigned []string
+	for _, c := range commits {
+		// Merge commits only bring in work that's already on another
+		// branch, and the bot creates unsigned ones itself when it
+		// updates a pull request's branch.
+		if len(c.Parents) > 1 {
+			continue
+		}
+		if !signedOffByAuthor(c.Commit) {
+			unsigned = append(unsigned, c.GetSHA())
+		}
+	}
+	return unsigned
+}
+
+// signedOffByAuthor returns whether the given commit has a Signed-off-by
+// trailer with its author's email address.
+func signedOffByAuthor(commit *github.Commit) bool {
+	if commit == nil || commit.Author == nil {
+		return false
+	}
+	email := commit.Author.GetEmail()
+	for _, value := range parseTrailers(commit.GetMessage(), "Signed-off-by") {
+		addr, err := mail.ParseAddress(value)
+		if err == nil && strings.EqualFold(addr.Address, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// dcoInstructions returns the comment explaining which commits of the given
+// pull request aren't signed off, and how to fix them.
+func dcoInstructions(pr *github.PullRequest, unsigned []string) string {
+	return fmt.Sprintf("@%s thanks for the pull request! We require every "+
+		"commit to be signed off by its author, certifying the "+
+		"[Developer Certificate of Origin](https://developercertificate.org/). "+
+		"These commits aren't signed off with their author's email "+
+		"address:\n\n- %s\n\nTo sign them off, run:\n\n"+
+		"```\ngit rebase --signoff %s\ngit push --force-with-lease\n```\n\n"+
+		"Make sure that `git config user.email` matches the email "+
+		"address the commits were authored with.",
+		pr.User.GetLogin(), strings.Join(unsigned, "\n- "),
+		pr.Base.GetSHA())
+}
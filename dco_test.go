@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// testCommit returns a commit by ann@example.com with the given message.
+func testCommit(message string) *github.Commit {
+	return &github.Commit{
+		Author:  &github.CommitAuthor{Email: github.String("ann@example.com")},
+		Message: github.String(message),
+	}
+}
+
+func TestSignedOffByAuthor(t *testing.T) {
+	tests := []struct {
+		name   string
+		commit *github.Commit
+		want   bool
+	}{
+		{
+			name:   "signed off",
+			commit: testCommit("Fix it\n\nSigned-off-by: Ann <ann@example.com>"),
+			want:   true,
+		},
+		{
+			name:   "email case differs",
+			commit: testCommit("Fix it\n\nSigned-off-by: Ann <Ann@Example.com>"),
+			want:   true,
+		},
+		{
+			name:   "signed off by someone else",
+			commit: testCommit("Fix it\n\nSigned-off-by: Bob <bob@example.com>"),
+			want:   false,
+		},
+		{
+			name: "one of several sign-offs",
+			commit: testCommit("Fix it\n\n" +
+				"Signed-off-by: Bob <bob@example.com>\n" +
+				"Signed-off-by: Ann <ann@example.com>"),
+			want: true,
+		},
+		{
+			name:   "not signed off",
+			commit: testCommit("Fix it"),
+			want:   false,
+		},
+		{
+			name:   "malformed sign-off",
+			commit: testCommit("Fix it\n\nSigned-off-by: ann@example.com>"),
+			want:   false,
+		},
+		{
+			name:   "no author",
+			commit: &github.Commit{Message: github.String("Fix it")},
+			want:   false,
+		},
+	}
+
+	for _, test := range tests {
+		if got := signedOffByAuthor(test.commit); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnsignedCommits(t *testing.T) {
+	commits := []*github.RepositoryCommit{
+		{
+			SHA: github.String("a"),
+			Commit: testCommit("Fix it\n\n" +
+				"Signed-off-by: Ann <ann@example.com>"),
+		},
+		{
+			SHA:    github.String("b"),
+			Commit: testCommit("Fix it again"),
+		},
+		{
+			// Merge commits don't need to be signed off.
+			SHA:     github.String("c"),
+			Commit:  testCommit("Merge branch 'master' into fix"),
+			Parents: []github.Commit{{}, {}},
+		},
+	}
+	got := unsignedCommits(commits)
+	if want := []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+const (
+	// descriptionContext is the context of the commit status reporting
+	// whether a pull request's description is complete.
+	descriptionContext = "kelda-bot/description"
+
+	// descriptionMarker identifies the bot's comment about what's missing
+	// from a pull request's description.
+	descriptionMarker = "<!-- kelda-bot:description -->"
+
+	// templateTTL is how long a repository's pull request template is
+	// cached.
+	templateTTL = time.Hour
+)
+
+// templatePaths are the places GitHub looks for a pull request template.
+var templatePaths = []string{
+	".github/pull_request_template.md",
+	".github/PULL_REQUEST_TEMPLATE.md",
+	"pull_request_template.md",
+	"PULL_REQUEST_TEMPLATE.md",
+	"docs/pull_request_template.md",
+	"docs/PULL_REQUEST_TEMPLATE.md",
+}
+
+var (
+	headingPattern     = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	htmlCommentPattern = regexp.MustCompile(`(?s)<!--.*?-->`)
+	issueLinkPattern   = regexp.MustCompile(
+		`(^|[\s(])([\w.-]+/[\w.-]+)?#\d+\b|github\.com/[\w.-]+/[\w.-]+/issues/\d+`)
+)
+
+// prTemplate is a repository's pull request template, split into sections.
+type prTemplate struct {
+	// sections maps each heading in the template to the text under it.
+	sections map[string]string
+	headings []string
+	fetched  time.Time
+}
+
+var (
+	templateCache     = map[string]prTemplate{}
+	templateCacheLock sync.Mutex
+)
+
+// checkDescription checks the title and body of the given pull request against
+// its repository's requirements, and reports the result as a commit status on
+// the PR's head. A single comment lists what's missing, and is edited as the
+// author fixes things.
+func checkDescription(client *github.Client, pr *github.PullRequest) {
+	if config.Repos[*pr.Base.Repo.Name].Description == nil {
+		return
+	}
+
+	missing := descriptionProblems(client, pr)
+	status := &github.RepoStatus{Context: github.String(descriptionContext)}
+	if len(missing) == 0 {
+		status.State = github.String("success")
+		status.Description = github.String("The description is complete")
+		setMarkedComment(client, pr, descriptionMarker,
+			"The description has everything it needs now. Thanks!", false)
+	} else {
+		status.State = github.String("failure")
+		status.Description = github.String(fmt.Sprintf(
+			"The description is missing %d things", len(missing)))
+		if len(missing) == 1 {
+			status.Description = github.String(
+				"The description is missing 1 thing")
+		}
+		url := setMarkedComment(client, pr, descriptionMarker, fmt.Sprintf(
+			"@%s thanks for the pull request! Please edit it to fix "+
+				"these, and I'll update this comment:\n\n- %s",
+			pr.User.GetLogin(), strings.Join(missing, "\n- ")), true)
+		if url != "" {
+			status.TargetURL = &url
+		}
+	}
+
+	_, _, err := client.Repositories.CreateStatus(ctx(), "kelda",
+		*pr.Base.Repo.Name, pr.Head.GetSHA(), status)
+	if err != nil {
+		log.WithError(err).Warnf("unable to set the description status "+
+			"of %s", prKey(pr))
+	}
+}
+
+// descriptionProblems returns what's missing from the title and body of the
+// given pull request. It's empty if the description is complete, or if the
+// repository doesn't check descriptions.
+func descriptionProblems(client *github.Client, pr *github.PullRequest) []string {
+	rules := config.Repos[*pr.Base.Repo.Name].Description
+	if rules == nil {
+		return nil
+	}
+
+	var missing []string
+	if rules.TitlePattern != "" {
+		pattern, err := regexp.Compile(rules.TitlePattern)
+		if err != nil {
+			log.WithError(err).Warnf("invalid title pattern for %s",
+				*pr.Base.Repo.Name)
+		} else if !pattern.MatchString(pr.GetTitle()) {
+			missing = append(missing, fmt.Sprintf(
+				"The title should match `%s`.", rules.TitlePattern))
+		}
+	}
+
+	body := htmlCommentPattern.ReplaceAllString(pr.GetBody(), "")
+	if rules.RequireIssue && !issueLinkPattern.MatchString(body) {
+		missing = append(missing, "Link the issue this fixes (e.g., "+
+			"\"Fixes #123\").")
+	}
+
+	template := getPRTemplate(client, *pr.Base.Repo.Name)
+	required := rules.Sections
+	if len(required) == 0 {
+		required = template.headings
+	}
+	sections, _ := splitSections(body)
+	for _, heading := range required {
+		key := strings.ToLower(heading)
+		content, ok := sections[key]
+		if !ok || content == "" || content == template.sections[key] {
+			missing = append(missing, fmt.Sprintf(
+				"Fill in the **%s** section.", heading))
+		}
+	}
+	return missing
+}
+
+// descriptionPasses returns whether the given pull request's description meets
+// its repository's requirements.
+func descriptionPasses(client *github.Client, pr *github.PullRequest) bool {
+	return len(descriptionProblems(client, pr)) == 0
+}
+
+// getPRTemplate returns the pull request template of the given repository. If
+// it has none, the template is empty.
+func getPRTemplate(client *github.Client, repo string) prTemplate {
+	templateCacheLock.Lock()
+	defer templateCacheLock.Unlock()
+	if cached, ok := templateCache[repo]; ok &&
+		time.Since(cached.fetched) < templateTTL {
+		return cached
+	}
+
+	template := prTemplate{sections: map[string]string{}, fetched: time.Now()}
+	for _, path := range templatePaths {
+		file, _, _, err := client.Repositories.GetContents(ctx(), "kelda",
+			repo, path, nil)
+		if err != nil || file == nil {
+			continue
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			log.WithError(err).Warnf("unable to decode %s of %s", path, repo)
+			continue
+		}
+		content = htmlCommentPattern.ReplaceAllString(content, "")
+		template.sections, template.headings = splitSections(content)
+		break
+	}
+	templateCache[repo] = template
+	return template
+}
+
+// splitSections splits Markdown text at its headings. It returns the trimmed
+// text under each heading, keyed by the lowercased heading, along with the
+// headings in order.
+func splitSections(text string) (map[string]string, []string) {
+	sections := map[string]string{}
+	var headings []string
+	current := ""
+	var lines []string
+	flush := func() {
+		if current != "" {
+			sections[strings.ToLower(current)] =
+				strings.TrimSpace(strings.Join(lines, "\n"))
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if match := headingPattern.FindStringSubmatch(line); match != nil {
+			flush()
+			current = match[1]
+			headings = append(headings, current)
+			lines = nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return sections, headings
+}
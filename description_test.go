@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSections(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantSections map[string]string
+		wantHeadings []string
+	}{
+		{
+			name:         "no headings",
+			text:         "Just some text.",
+			wantSections: map[string]string{},
+			wantHeadings: nil,
+		},
+		{
+			name: "sections",
+			text: "Intro is dropped.\n## Summary\nFixes the thing.\r\n\n" +
+				"### Testing ###\n\nRan the tests.\nTwice.\n",
+			wantSections: map[string]string{
+				"summary": "Fixes the thing.",
+				"testing": "Ran the tests.\nTwice.",
+			},
+			wantHeadings: []string{"Summary", "Testing"},
+		},
+		{
+			name: "empty section",
+			text: "# Summary\n# Testing\nRan the tests.",
+			wantSections: map[string]string{
+				"summary": "",
+				"testing": "Ran the tests.",
+			},
+			wantHeadings: []string{"Summary", "Testing"},
+		},
+	}
+
+	for _, test := range tests {
+		sections, headings := splitSections(test.text)
+		if !reflect.DeepEqual(sections, test.wantSections) {
+			t.Errorf("%s: got sections %q, want %q", test.name, sections,
+				test.wantSections)
+		}
+		if !reflect.DeepEqual(headings, test.wantHeadings) {
+			t.Errorf("%s: got headings %q, want %q", test.name, headings,
+				test.wantHeadings)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// The stages a pull request goes through under the two-stage review policy.
+const (
+	stageFirstReview     = "waiting for a first review"
+	stageInReview        = "in review"
+	stageCommitterReview = "waiting for a committer review"
+	stageApproved        = "approved by a committer"
+)
+
+// prSummary is what processPullRequest found out about an open pull request.
+type prSummary struct {
+	pr    *github.PullRequest
+	stage string
+
+	// waiting maps each reviewer who hasn't acted on their review request
+	// to when the review was requested.
+	waiting map[string]time.Time
+}
+
+var (
+	// reviewSnapshot holds the summaries of all open pull requests from
+	// the last complete run of runReview.
+	reviewSnapshot     []prSummary
+	reviewSnapshotTime time.Time
+	reviewSnapshotLock sync.Mutex
+)
+
+// maxDigestSnapshotAge is how old the review snapshot can get before digests
+// are held back, so that no one is sent a digest that's out of date because
+// runReview has been failing.
+const maxDigestSnapshotAge = 30 * time.Minute
+
+// setReviewSnapshot replaces the summaries of the open pull requests.
+func setReviewSnapshot(summaries []prSummary) {
+	reviewSnapshotLock.Lock()
+	defer reviewSnapshotLock.Unlock()
+	reviewSnapshot = summaries
+	reviewSnapshotTime = time.Now()
+}
+
+// reviewSnapshotTaken returns when the review snapshot was last replaced, or
+// the zero time if it never was.
+func reviewSnapshotTaken() time.Time {
+	reviewSnapshotLock.Lock()
+	defer reviewSnapshotLock.Unlock()
+	return reviewSnapshotTime
+}
+
+// getReviewSnapshot returns the summaries of the open pull requests from the
+// last complete run of runReview.
+func getReviewSnapshot() []prSummary {
+	reviewSnapshotLock.Lock()
+	defer reviewSnapshotLock.Unlock()
+	return reviewSnapshot
+}
+
+// sendDigests sends each team member who hasn't had one today a digest of the
+// pull requests waiting for them, once their working day has started. It uses
+// the summaries from the last run of runReview rather than asking GitHub again,
+// and sends nothing if that run was too long ago. Digests for people in Do Not
+// Disturb wait until it ends, so that they're up to date when they're read.
+func sendDigests(client *github.Client, slackClient *slack.Client) {
+	if time.Since(reviewSnapshotTaken()) > maxDigestSnapshotAge {
+		log.Warn("the review snapshot is out of date; not sending digests")
+		return
+	}
+
+	members, _ := getTeamMembers(client)
+	summaries := getReviewSnapshot()
+	now := time.Now()
+	for _, login := range members {
+		slackID := config.Users[login].Slack
+		loc := userLocation(slackClient, login)
+		today := now.In(loc).Format(dateFormat)
+		if slackID == "" || !inWorkingHours(now, loc) || isAway(login, now) {
+			continue
+		}
+
+		sent := false
+		viewState(func(s *botState) { sent = s.Digests[login] == today })
+		if sent {
+			continue
+		}
+
+		// Only a digest that was actually sent counts for the day; an
+		// empty one is tried again later in case something comes up.
+		text := buildDigest(login, summaries, now, loc)
+		if text == "" || dndEnd(slackClient, slackID).After(now) {
+			continue
+		}
+		if channel, _ := sendDirectMessage(slackClient, slackID, text,
+			nil); channel == "" {
+			continue
+		}
+		updateState(func(s *botState) {
+			if s.Digests == nil {
+				s.Digests = map[string]string{}
+			}
+			s.Digests[login] = today
+		})
+	}
+}
+
+// buildDigest returns the digest for the given person, or an empty string if
+// there's nothing waiting for them.
+func buildDigest(login string, summaries []prSummary, now time.Time,
+	loc *time.Location) string {
+	var toReview, own, attention []string
+	for _, summary := range summaries {
+		pr := summary.pr
+		if since, ok := summary.waiting[login]; ok {
+			toReview = append(toReview, fmt.Sprintf(
+				"• %s (waiting %.0f working hours)", prLink(pr),
+				businessHoursBetween(since, now, loc).Hours()))
+		}
+
+		if pr.User.GetLogin() != login {
+			continue
+		}
+		stage := summary.stage
+		if stage == "" {
+			stage = "unknown"
+		}
+		own = append(own, fmt.Sprintf("• %s: %s", prLink(pr), stage))
+		if hasMergeConflict(pr) {
+			attention = append(attention, fmt.Sprintf(
+				"• %s has merge conflicts", prLink(pr)))
+		}
+		if failing := failingChecks(pr); len(failing) > 0 {
+			attention = append(attention, fmt.Sprintf(
+				"• %s is failing %s", prLink(pr),
+				strings.Join(failing, ", ")))
+		}
+	}
+	if len(toReview) == 0 && len(own) == 0 {
+		return ""
+	}
+
+	lines := []string{"Good morning! Here's where your pull requests stand."}
+	if len(toReview) > 0 {
+		lines = append(lines, "*Waiting for your review:*")
+		lines = append(lines, toReview...)
+	}
+	if len(own) > 0 {
+		lines = append(lines, "*Your pull requests:*")
+		lines = append(lines, own...)
+	}
+	if len(attention) > 0 {
+		lines = append(lines, "*Needs your attention:*")
+		lines = append(lines, attention...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// failingChecks returns the checks that are failing on the head commit of the
+// given pull request.
+func failingChecks(pr *github.PullRequest) []string {
+	var failing []string
+	viewState(func(s *botState) {
+		for context := range s.CIFailures[ciFailureKey(pr.Head.GetSHA(), prKey(pr))].Failing {
+			failing = append(failing, context)
+		}
+	})
+	sort.Strings(failing)
+	return failing
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+const (
+	// healthReportPeriod is how far back the review health report looks.
+	healthReportPeriod = 7 * 24 * time.Hour
+
+	// oldestPRCount is how many of the oldest open pull requests the
+	// review health report lists.
+	oldestPRCount = 5
+)
+
+// healthSheetName is the sheet in the metrics spreadsheet that the review
+// health reports are appended to.
+var healthSheetName = "Review Health"
+
+// reviewHealth is the data in a review health report.
+type reviewHealth struct {
+	// opened and merged count the pull requests opened and merged in each
+	// repository.
+	opened, merged map[string]int
+
+	// firstReview holds how long each pull request waited for its first
+	// review, and committerReview holds how long each waited for a
+	// committer's review after a non-committer approved it.
+	firstReview, committerReview []time.Duration
+
+	// reviews and assignments count the pull requests each person
+	// reviewed, and the ones they were asked to review.
+	reviews, assignments map[string]int
+
+	oldest []*github.PullRequest
+}
+
+// Kinds of review activity.
+const (
+	activityOpened          = "opened"
+	activityMerged          = "merged"
+	activityAssignment      = "assignment"
+	activityReview          = "review"
+	activityFirstReview     = "firstReview"
+	activityCommitterReview = "committerReview"
+)
+
+// reviewActivity is something that happened to a pull request, recorded from
+// webhooks as it happens so that the review health report doesn't need to ask
+// GitHub for the past week's history.
+type reviewActivity struct {
+	Kind string    `json:"kind"`
+	Repo string    `json:"repo"`
+	At   time.Time `json:"at"`
+
+	// Login is who was asked to review, or who reviewed.
+	Login string `json:"login,omitempty"`
+
+	// Wait is how long the pull request waited for its first review, or
+	// for a committer's review after a non-committer approved it.
+	Wait time.Duration `json:"wait,omitempty"`
+}
+
+// reviewProgress is what the review health report needs to remember about an
+// open pull request's reviews.
+type reviewProgress struct {
+	// Reviewed holds everyone who has reviewed the pull request.
+	Reviewed []string `json:"reviewed,omitempty"`
+
+	// ApprovedAt is when a non-committer approved the pull request, if
+	// no committer has reviewed it since.
+	ApprovedAt time.Time `json:"approvedAt,omitempty"`
+}
+
+// maybeSendHealthReport sends the review health report for the past week, if
+// it hasn't been sent yet this week. Since the report only covers the activity
+// the bot has recorded, it isn't sent until a full week has been recorded.
+func maybeSendHealthReport(googleClient *sheets.Service,
+	slackClient *slack.Client) {
+	now := time.Now()
+	year, week := now.ISOWeek()
+	thisWeek := fmt.Sprintf("%d-W%02d", year, week)
+	sent := false
+	var recordingSince time.Time
+	viewState(func(s *botState) {
+		sent = s.HealthReportWeek == thisWeek
+		recordingSince = s.ReviewActivityStart
+	})
+	if sent || recordingSince.IsZero() ||
+		now.Sub(recordingSince) < healthReportPeriod {
+		return
+	}
+
+	health := collectReviewHealth(now.Add(-healthReportPeriod))
+	report := formatReviewHealth(health, now)
+	if config.HealthReportChannel != "" {
+		postToChannel(slackClient, config.HealthReportChannel, report)
+	} else {
+		log.Info(report)
+	}
+
+	header := []interface{}{"Week Ending", "Measure", "Name", "Value"}
+	if err := ensureSheet(googleClient, healthSheetName, header); err != nil {
+		log.WithError(err).Warnf("unable to create the %s sheet", healthSheetName)
+	} else if err := appendRows(googleClient, healthSheetName,
+		reviewHealthRows(health, now)); err != nil {
+		log.WithError(err).Warnf("unable to append to the %s sheet",
+			healthSheetName)
+	}
+
+	updateState(func(s *botState) {
+		s.HealthReportWeek = thisWeek
+	})
+}
+
+// collectReviewHealth gathers the review health data from the review activity
+// recorded since the given time, and the open pull requests from the last run
+// of runReview.
+func collectReviewHealth(since time.Time) *reviewHealth {
+	health := &reviewHealth{
+		opened:      map[string]int{},
+		merged:      map[string]int{},
+		reviews:     map[string]int{},
+		assignments: map[string]int{},
+	}
+	viewState(func(s *botState) {
+		for _, a := range s.ReviewActivity {
+			if a.At.Before(since) {
+				continue
+			}
+			switch a.Kind {
+			case activityOpened:
+				health.opened[a.Repo]++
+			case activityMerged:
+				health.merged[a.Repo]++
+			case activityAssignment:
+				health.assignments[a.Login]++
+			case activityReview:
+				health.reviews[a.Login]++
+			case activityFirstReview:
+				health.firstReview = append(health.firstReview, a.Wait)
+			case activityCommitterReview:
+				health.committerReview = append(health.committerReview,
+					a.Wait)
+			}
+		}
+	})
+
+	for _, summary := range getReviewSnapshot() {
+		health.oldest = append(health.oldest, summary.pr)
+	}
+	sort.Slice(health.oldest, func(i, j int) bool {
+		return health.oldest[i].GetCreatedAt().Before(
+			health.oldest[j].GetCreatedAt())
+	})
+	if len(health.oldest) > oldestPRCount {
+		health.oldest = health.oldest[:oldestPRCount]
+	}
+	return health
+}
+
+// recordReviewActivity adds the given activity to the bot's state, and drops
+// activity that's too old to be in a report.
+func recordReviewActivity(s *botState, activity reviewActivity) {
+	if s.ReviewActivityStart.IsZero() {
+		s.ReviewActivityStart = time.Now()
+	}
+	cutoff := time.Now().Add(-2 * healthReportPeriod)
+	kept := s.ReviewActivity[:0]
+	for _, a := range s.ReviewActivity {
+		if !a.At.Before(cutoff) {
+			kept = append(kept, a)
+		}
+	}
+	s.ReviewActivity = append(kept, activity)
+}
+
+// recordPRHealth records the opening, merging, and review requests of pull
+// requests for the review health report.
+func recordPRHealth(event *github.PullRequestEvent, payload []byte) {
+	pr := event.PullRequest
+	activity := reviewActivity{Repo: *pr.Base.Repo.Name}
+	switch event.GetAction() {
+	case "opened":
+		activity.Kind = activityOpened
+		activity.At = pr.GetCreatedAt()
+	case "closed":
+		if !pr.GetMerged() {
+			return
+		}
+		activity.Kind = activityMerged
+		activity.At = pr.GetMergedAt()
+	case "review_requested":
+		activity.Kind = activityAssignment
+		activity.At = time.Now()
+		activity.Login = requestedReviewer(payload)
+		if activity.Login == "" {
+			return
+		}
+	default:
+		return
+	}
+	updateState(func(s *botState) {
+		recordReviewActivity(s, activity)
+	})
+}
+
+// recordReviewHealth records a submitted review for the review health report:
+// who did it, and, if it's the pull request's first review or the first
+// committer review since a non-committer approved it, how long it took.
+func recordReviewHealth(client *github.Client,
+	event *github.PullRequestReviewEvent) {
+	pr := event.PullRequest
+	reviewer := event.Review.User.GetLogin()
+	if event.GetAction() != "submitted" || reviewer == pr.User.GetLogin() {
+		return
+	}
+	_, committers := getTeamMembers(client)
+	isCommitter := userInList(&reviewer, committers)
+	approved := strings.ToUpper(event.Review.GetState()) == "APPROVED"
+	at := event.Review.GetSubmittedAt()
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	repo := *pr.Base.Repo.Name
+	updateState(func(s *botState) {
+		if s.ReviewProgress == nil {
+			s.ReviewProgress = map[string]reviewProgress{}
+		}
+		progress := s.ReviewProgress[prKey(pr)]
+		if len(progress.Reviewed) == 0 {
+			recordReviewActivity(s, reviewActivity{
+				Kind: activityFirstReview,
+				Repo: repo,
+				At:   at,
+				Wait: at.Sub(pr.GetCreatedAt()),
+			})
+		}
+		if !userInList(&reviewer, progress.Reviewed) {
+			progress.Reviewed = append(progress.Reviewed, reviewer)
+			recordReviewActivity(s, reviewActivity{
+				Kind:  activityReview,
+				Repo:  repo,
+				At:    at,
+				Login: reviewer,
+			})
+		}
+
+		switch {
+		case !isCommitter && approved && progress.ApprovedAt.IsZero():
+			progress.ApprovedAt = at
+		case isCommitter && !progress.ApprovedAt.IsZero():
+			// Only the first committer review after the approval
+			// counts.
+			recordReviewActivity(s, reviewActivity{
+				Kind: activityCommitterReview,
+				Repo: repo,
+				At:   at,
+				Wait: at.Sub(progress.ApprovedAt),
+			})
+			progress.ApprovedAt = time.Time{}
+		}
+		s.ReviewProgress[prKey(pr)] = progress
+	})
+}
+
+// formatReviewHealth returns the review health report as a Slack message.
+func formatReviewHealth(health *reviewHealth, now time.Time) string {
+	lines := []string{fmt.Sprintf("*Review health for the week ending %s*",
+		now.Format(dateFormat))}
+
+	lines = append(lines, "*Pull requests opened and merged:*")
+	for _, repo := range sortedKeys(health.opened, health.merged) {
+		lines = append(lines, fmt.Sprintf("• %s: %d opened, %d merged",
+			repo, health.opened[repo], health.merged[repo]))
+	}
+
+	lines = append(lines, fmt.Sprintf("*Median time to first review:* %s",
+		formatMedian(health.firstReview)))
+	lines = append(lines, fmt.Sprintf("*Median time from approval to "+
+		"committer review:* %s", formatMedian(health.committerReview)))
+
+	lines = append(lines, "*Reviews done / assigned:*")
+	for _, login := range sortedKeys(health.reviews, health.assignments) {
+		lines = append(lines, fmt.Sprintf("• %s: %d / %d", login,
+			health.reviews[login], health.assignments[login]))
+	}
+
+	if len(health.oldest) > 0 {
+		lines = append(lines, "*Oldest open pull requests:*")
+		for _, pr := range health.oldest {
+			lines = append(lines, fmt.Sprintf("• %s (opened %s)",
+				prLink(pr), pr.GetCreatedAt().Format(dateFormat)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reviewHealthRows returns the review health report as rows for the health
+// sheet.
+func reviewHealthRows(health *reviewHealth, now time.Time) [][]interface{} {
+	date := now.Format(timeFormat)
+	var rows [][]interface{}
+	for _, repo := range sortedKeys(health.opened, health.merged) {
+		rows = append(rows,
+			[]interface{}{date, "PRs opened", repo, health.opened[repo]},
+			[]interface{}{date, "PRs merged", repo, health.merged[repo]})
+	}
+	rows = append(rows,
+		[]interface{}{date, "Median hours to first review", "",
+			medianHours(health.firstReview)},
+		[]interface{}{date, "Median hours from approval to committer review",
+			"", medianHours(health.committerReview)})
+	for _, login := range sortedKeys(health.reviews, health.assignments) {
+		rows = append(rows,
+			[]interface{}{date, "Reviews", login, health.reviews[login]},
+			[]interface{}{date, "Assignments", login,
+				health.assignments[login]})
+	}
+	for _, pr := range health.oldest {
+		rows = append(rows, []interface{}{date, "Oldest open PR", prKey(pr),
+			pr.GetCreatedAt().Format(timeFormat)})
+	}
+	return rows
+}
+
+// median returns the median of the given durations, or zero if there are
+// none.
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// medianHours returns the median of the given durations in hours, or an empty
+// string if there are none.
+func medianHours(durations []time.Duration) interface{} {
+	if len(durations) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f", median(durations).Hours())
+}
+
+// formatMedian describes the median of the given durations.
+func formatMedian(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f hours (%d pull requests)",
+		median(durations).Hours(), len(durations))
+}
+
+// sortedKeys returns the keys of the given maps, sorted and without
+// duplicates.
+func sortedKeys(maps ...map[string]int) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
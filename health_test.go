@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      time.Duration
+	}{
+		{name: "none", durations: nil, want: 0},
+		{name: "one", durations: []time.Duration{time.Hour}, want: time.Hour},
+		{
+			name: "odd",
+			durations: []time.Duration{5 * time.Hour, time.Hour,
+				3 * time.Hour},
+			want: 3 * time.Hour,
+		},
+		{
+			name: "even",
+			durations: []time.Duration{4 * time.Hour, time.Hour,
+				2 * time.Hour, 10 * time.Hour},
+			want: 3 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		got := median(test.durations)
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	durations := []time.Duration{3, 1, 2}
+	median(durations)
+	if durations[0] != 3 || durations[1] != 1 || durations[2] != 2 {
+		t.Errorf("median reordered its argument: %v", durations)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// useTestState gives the test an empty bot state that's saved in a temporary
+// directory, and returns a function that restores the real one.
+func useTestState(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "bot-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := statePath
+	statePath = filepath.Join(dir, "state.json")
+	state = botState{}
+	return func() {
+		statePath = oldPath
+		state = botState{}
+		os.RemoveAll(dir)
+	}
+}
+
+// newFakeSlack returns a Slack client whose API calls are answered by respond,
+// which is given the API method and its arguments and returns the JSON
+// response, along with a function that shuts the fake down.
+func newFakeSlack(respond func(method string, args url.Values) string) (
+	*slack.Client, func()) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			method := strings.TrimPrefix(r.URL.Path, "/")
+			fmt.Fprint(w, respond(method, r.PostForm))
+		}))
+	oldAPI := slack.SLACK_API
+	slack.SLACK_API = server.URL + "/"
+	return slack.New("test-token"), func() {
+		slack.SLACK_API = oldAPI
+		server.Close()
+	}
+}
+
+// newFakeGitHub returns a GitHub client whose requests are served by handler,
+// along with a function that shuts the fake down.
+func newFakeGitHub(handler http.Handler) (*github.Client, func()) {
+	server := httptest.NewServer(handler)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server.Close
+}
+
+// serveJSON makes mux answer requests for path with body.
+func serveJSON(mux *http.ServeMux, path, body string) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	})
+}
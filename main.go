@@ -1,21 +1,22 @@
 package main
 
 import (
+	"fmt"
 	"golang.org/x/net/context"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"golang.org/x/oauth2"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/google/go-github/github"
 	"github.com/nlopes/slack"
 )
 
 func main() {
-	log.Println("Started!")
+	log.Info("Started!")
 
 	// Initialize the various clients so we can re-use them.
 	ts := oauth2.StaticTokenSource(
@@ -25,6 +26,17 @@ func main() {
 
 	githubClient := github.NewClient(tc)
 
+	if len(os.Args) > 1 && os.Args[1] == "changelog" {
+		// Run as a command line tool: print the changelog and exit.
+		changelog, err := changelogCommand(githubClient,
+			commandRequest{args: os.Args[1:]})
+		if err != nil {
+			log.Fatalf("Unable to generate the changelog: %s", err)
+		}
+		fmt.Println(changelog)
+		return
+	}
+
 	gsName := "google_secret.json"
 	gs, err := ioutil.ReadFile(gsName)
 	if err != nil {
@@ -37,14 +49,43 @@ func main() {
 
 	st := os.Getenv("SLACK_TOKEN")
 	slackClient := slack.New(st)
+	slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	githubWebhookSecret = os.Getenv("GITHUB_WEBHOOK_SECRET")
+
+	configName := "config.json"
+	if err := loadConfig(configName); err != nil {
+		log.Fatalf("Unable to load config from %s: %s", configName, err)
+	}
+	if config.HolidayCalendar != "" {
+		if err := loadHolidays(config.HolidayCalendar); err != nil {
+			log.WithError(err).Warnf("unable to load holidays from %s",
+				config.HolidayCalendar)
+		}
+	}
+	if path := os.Getenv("STATE_FILE"); path != "" {
+		statePath = path
+	}
+	if err := loadState(); err != nil {
+		log.Fatalf("Unable to load state from %s: %s", statePath, err)
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		switch github.WebHookType(r) {
-		case "pull_request_review", "pull_request":
-			runReview(githubClient)
+		payload, err := readGithubWebhook(r)
+		if err != nil {
+			log.WithError(err).Warn("rejected webhook")
+			http.Error(w, "invalid request", http.StatusUnauthorized)
+			return
 		}
+		// Handling an event can mean a full review run, which takes
+		// longer than GitHub waits for a response, so answer right away.
+		go handleGithubEvent(githubClient, googleClient, slackClient,
+			github.WebHookType(r), payload)
 	})
+	http.HandleFunc("/slack/command", slackCommandHandler(githubClient))
+	http.HandleFunc("/slack/actions",
+		slackActionHandler(githubClient, slackClient))
 	go http.ListenAndServe(":80", nil)
+	go runChatBot(githubClient, googleClient, slackClient)
 
 	reviewTicker := time.Tick(10 * time.Minute)
 
@@ -53,12 +94,34 @@ func main() {
 	// make sure we don't miss a day of data).
 	metricsTicker := time.Tick(12 * time.Hour)
 
+	branchSweepTicker := time.Tick(24 * time.Hour)
+
+	staleSweepTicker := time.Tick(24 * time.Hour)
+
+	// The review health report goes out once a week; checking hourly
+	// means it's sent soon after the week starts, even across restarts.
+	healthReportTicker := time.Tick(time.Hour)
+
+	// Notifications for people in Do Not Disturb are held until their DND
+	// period ends, so check often for ones that can be sent.
+	notificationTicker := time.Tick(time.Minute)
+
 	for {
 		select {
 		case <-reviewTicker:
-			runReview(githubClient)
+			runReview(githubClient, slackClient)
+			sendDigests(githubClient, slackClient)
+			remindTriagers(githubClient, slackClient)
 		case <-metricsTicker:
 			recordMetrics(githubClient, googleClient, slackClient)
+		case <-branchSweepTicker:
+			sweepStaleBranches(githubClient, slackClient)
+		case <-staleSweepTicker:
+			sweepStaleItems(githubClient, slackClient)
+		case <-healthReportTicker:
+			maybeSendHealthReport(googleClient, slackClient)
+		case <-notificationTicker:
+			sendQueuedNotifications(slackClient)
 		}
 	}
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// handleMembershipChange refreshes the cached team lists after someone joins
+// or leaves a team or the organization. If the person is no longer on either
+// the Reviewers or Committers team, their pending reviews are reassigned.
+func handleMembershipChange(client *github.Client, slackClient *slack.Client,
+	login string) {
+	if login == "" {
+		return
+	}
+
+	members, committers := refreshTeamMembers(client)
+	if userInList(&login, members) || userInList(&login, committers) {
+		return
+	}
+	reassignReviews(client, slackClient, login)
+}
+
+// handleTeamChange refreshes the cached team lists after the Reviewers or
+// Committers team is changed (e.g., renamed or deleted), and reassigns the
+// pending reviews of everyone who's no longer on either team as a result.
+func handleTeamChange(client *github.Client, slackClient *slack.Client) {
+	cachedMembers, cachedCommitters := cachedTeamMembers()
+	previous := append(append([]string{}, cachedMembers...),
+		cachedCommitters...)
+
+	members, committers := refreshTeamMembers(client)
+	for _, login := range previous {
+		if !userInList(&login, members) && !userInList(&login, committers) {
+			reassignReviews(client, slackClient, login)
+		}
+	}
+}
+
+// isReviewTeam returns whether the given team is one of the teams that reviews
+// are assigned from.
+func isReviewTeam(team *github.Team) bool {
+	name := team.GetName()
+	return name == "Reviewers" || name == "Committers"
+}
+
+// reassignReviews removes the given user from every open pull request they've
+// been asked to review, and assigns someone else in their place.
+func reassignReviews(client *github.Client, slackClient *slack.Client,
+	login string) {
+	query := fmt.Sprintf("org:kelda is:pr is:open review-requested:%s", login)
+	opt := &github.SearchOptions{}
+	var issues []github.Issue
+	for {
+		result, resp, err := client.Search.Issues(ctx(), query, opt)
+		if err != nil {
+			log.WithError(err).Warnf("unable to find reviews held by %s", login)
+			return
+		}
+		issues = append(issues, result.Issues...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	for _, issue := range issues {
+		repo, err := repoFromHTMLURL(issue.GetHTMLURL())
+		if err != nil {
+			log.WithError(err).Warnf("unable to reassign review of %s",
+				issue.GetHTMLURL())
+			continue
+		}
+		pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo,
+			issue.GetNumber())
+		if err != nil {
+			log.WithError(err).Warnf("unable to get PR %s#%d",
+				repo, issue.GetNumber())
+			continue
+		}
+
+		log.Infof("Removing %s as a reviewer of %s", login, prKey(pr))
+		remove := map[string][]string{"reviewers": {login}}
+		err = prRequest(client, pr, "DELETE", "requested_reviewers", &remove, nil)
+		if err != nil {
+			log.WithError(err).Warnf("unable to remove %s from %s",
+				login, prKey(pr))
+			continue
+		}
+
+		// Now that the departed reviewer is gone, the usual review
+		// logic assigns someone new at the same stage.
+		processPullRequest(client, slackClient, pr)
+	}
+}
+
+// repoFromHTMLURL returns the name of the repository that contains the issue or
+// pull request with the given web URL (e.g.,
+// "https://github.com/kelda/bot/pull/12").
+func repoFromHTMLURL(url string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(url, "https://github.com/"), "/")
+	if len(parts) < 2 || parts[0] != "kelda" {
+		return "", fmt.Errorf("unexpected URL %q", url)
+	}
+	return parts[1], nil
+}
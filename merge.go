@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// handleApprovedPR announces that a committer-approved pull request is ready
+// to merge once its status checks pass, and merges it if its repository has
+// opted in to auto-merge. Each head commit is only handled once, but failed
+// merges are retried until they succeed.
+func handleApprovedPR(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest) {
+	sha := pr.Head.GetSHA()
+	handled := false
+	viewState(func(s *botState) {
+		handled = s.MergeReady[prKey(pr)] == sha
+	})
+	if handled || !statusIsGreen(client, pr) {
+		return
+	}
+
+	link := prLink(pr)
+	author := *pr.User.Login
+	if !config.Repos[*pr.Base.Repo.Name].AutoMerge {
+		setMergeReady(pr)
+		if config.MergeChannel != "" {
+			postToChannel(slackClient, config.MergeChannel, fmt.Sprintf(
+				":white_check_mark: %s by %s is approved and green, "+
+					"and ready to merge.", link, author))
+		}
+		notifyUser(slackClient, author, fmt.Sprintf(
+			"%s is approved and green, and ready to merge.", link))
+		return
+	}
+
+	if err := mergePR(client, pr); err != nil {
+		log.WithError(err).Warnf("unable to auto-merge %s", prKey(pr))
+		alreadyTold := false
+		updateState(func(s *botState) {
+			alreadyTold = s.MergeFailed[prKey(pr)] == sha
+			if s.MergeFailed == nil {
+				s.MergeFailed = map[string]string{}
+			}
+			s.MergeFailed[prKey(pr)] = sha
+		})
+		if !alreadyTold {
+			notifyUser(slackClient, author, fmt.Sprintf(
+				"%s is approved and green, but I couldn't merge it: "+
+					"%s. I'll keep trying.", link, err))
+		}
+		return
+	}
+	setMergeReady(pr)
+
+	if config.MergeChannel != "" {
+		postToChannel(slackClient, config.MergeChannel, fmt.Sprintf(
+			":tada: %s by %s was approved and green, so I merged it.",
+			link, author))
+	}
+	notifyUser(slackClient, author, fmt.Sprintf(
+		"%s was approved and green, so I merged it.", link))
+}
+
+// setMergeReady records that the head commit of the given pull request has
+// been handled by handleApprovedPR.
+func setMergeReady(pr *github.PullRequest) {
+	updateState(func(s *botState) {
+		if s.MergeReady == nil {
+			s.MergeReady = map[string]string{}
+		}
+		s.MergeReady[prKey(pr)] = pr.Head.GetSHA()
+		delete(s.MergeFailed, prKey(pr))
+	})
+}
+
+// mergePR merges the given pull request with its repository's configured
+// merge method. The merge fails if the PR's head has changed since pr was
+// fetched.
+func mergePR(client *github.Client, pr *github.PullRequest) error {
+	log.Infof("Merging %s", prKey(pr))
+	opts := &github.PullRequestOptions{
+		SHA:         pr.Head.GetSHA(),
+		MergeMethod: config.Repos[*pr.Base.Repo.Name].MergeMethod,
+	}
+	result, _, err := client.PullRequests.Merge(ctx(), "kelda",
+		*pr.Base.Repo.Name, *pr.Number, "", opts)
+	if err != nil {
+		return err
+	}
+	if !result.GetMerged() {
+		return fmt.Errorf("GitHub refused the merge: %s", result.GetMessage())
+	}
+	return nil
+}
+
+// statusIsGreen returns whether all of the status checks and check runs on
+// the head commit of the given pull request have passed. A commit without any
+// checks counts as green.
+func statusIsGreen(client *github.Client, pr *github.PullRequest) bool {
+	state, err := commitChecksState(client, *pr.Base.Repo.Name,
+		pr.Head.GetSHA())
+	if err != nil {
+		log.WithError(err).Warnf("unable to get status of %s", prKey(pr))
+		return false
+	}
+	return state == "success" || state == ""
+}
+
+// checkRun is the part of a check run that the bot uses. The vendored GitHub
+// client predates the checks API, so we decode it ourselves.
+type checkRun struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+}
+
+// commitChecksState returns the overall result of the status checks and check
+// runs on the given commit: "success" if they all passed, "failure" if any of
+// them failed, and "pending" otherwise. It returns the empty string if the
+// commit has neither status checks nor check runs.
+func commitChecksState(client *github.Client, repo, sha string) (string, error) {
+	status, _, err := client.Repositories.GetCombinedStatus(ctx(), "kelda",
+		repo, sha, nil)
+	if err != nil {
+		return "", err
+	}
+	runs, err := listCheckRuns(client, repo, sha)
+	if err != nil {
+		return "", err
+	}
+
+	// GitHub reports a commit without any statuses as pending, so only
+	// look at the combined state if there's something to combine.
+	var states []string
+	if status.GetTotalCount() > 0 {
+		states = append(states, status.GetState())
+	}
+	for _, run := range runs {
+		switch {
+		case run.Status != "completed":
+			states = append(states, "pending")
+		case run.Conclusion == "success" || run.Conclusion == "neutral" ||
+			run.Conclusion == "skipped":
+			states = append(states, "success")
+		default:
+			states = append(states, "failure")
+		}
+	}
+
+	result := ""
+	for _, state := range states {
+		switch {
+		case state == "failure" || state == "error":
+			return "failure", nil
+		case state == "pending":
+			result = "pending"
+		case result == "":
+			result = "success"
+		}
+	}
+	return result, nil
+}
+
+// listCheckRuns returns all of the check runs on the given commit.
+func listCheckRuns(client *github.Client, repo, sha string) ([]checkRun, error) {
+	var runs []checkRun
+	url := fmt.Sprintf("/repos/kelda/%s/commits/%s/check-runs?per_page=100",
+		repo, sha)
+	for url != "" {
+		req, err := client.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github.antiope-preview+json")
+
+		var page struct {
+			CheckRuns []checkRun `json:"check_runs"`
+		}
+		resp, err := client.Do(ctx(), req, &page)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, page.CheckRuns...)
+
+		url = ""
+		if resp.NextPage != 0 {
+			url = fmt.Sprintf("/repos/kelda/%s/commits/%s/check-runs?"+
+				"per_page=100&page=%d", repo, sha, resp.NextPage)
+		}
+	}
+	return runs, nil
+}
+
+// openPRsForCommit returns the open pull requests in the given repository
+// whose head is the given commit.
+func openPRsForCommit(client *github.Client, repo, sha string) []*github.PullRequest {
+	query := fmt.Sprintf("repo:kelda/%s is:pr is:open %s", repo, sha)
+	result, _, err := client.Search.Issues(ctx(), query, nil)
+	if err != nil {
+		log.WithError(err).Warnf("unable to find PRs for commit %s", sha)
+		return nil
+	}
+
+	var prs []*github.PullRequest
+	for _, issue := range result.Issues {
+		pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo,
+			issue.GetNumber())
+		if err != nil {
+			log.WithError(err).Warnf("unable to get PR %s#%d",
+				repo, issue.GetNumber())
+			continue
+		}
+		if pr.Head.GetSHA() == sha {
+			prs = append(prs, pr)
+		}
+	}
+	return prs
+}
+
+// handleStatus reprocesses the open pull requests whose head commit just
+// passed a status check, since they may now be ready to merge, and lets the
+// repository's merge queue react to the result of the check. Authors are told
+// about failed checks.
+func handleStatus(client *github.Client, slackClient *slack.Client,
+	event *github.StatusEvent) {
+	handleCIResult(client, slackClient, event.Repo.GetName(), event.GetSHA(),
+		event.GetContext(), event.GetState(), event.GetDescription(),
+		event.GetTargetURL())
+	if config.Repos[event.Repo.GetName()].MergeQueue {
+		advanceMergeQueue(client, slackClient, event.Repo.GetName())
+	}
+	if event.GetState() != "success" {
+		return
+	}
+	for _, pr := range openPRsForCommit(client, event.Repo.GetName(),
+		event.GetSHA()) {
+		processPullRequest(client, slackClient, pr)
+	}
+}
+
+// prLink returns a Slack link to the given pull request.
+func prLink(pr *github.PullRequest) string {
+	return fmt.Sprintf("<%s|%s: %s>", pr.GetHTMLURL(), prKey(pr),
+		pr.GetTitle())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCommitChecksState(t *testing.T) {
+	const (
+		noStatuses = `{"state": "pending", "total_count": 0}`
+		noRuns     = `{"check_runs": []}`
+	)
+	tests := []struct {
+		name      string
+		status    string
+		checkRuns string
+		want      string
+	}{
+		{
+			name:      "nothing to check",
+			status:    noStatuses,
+			checkRuns: noRuns,
+			want:      "",
+		},
+		{
+			name:      "statuses passed",
+			status:    `{"state": "success", "total_count": 2}`,
+			checkRuns: noRuns,
+			want:      "success",
+		},
+		{
+			name:   "check runs passed",
+			status: noStatuses,
+			checkRuns: `{"check_runs": [
+				{"status": "completed", "conclusion": "success"},
+				{"status": "completed", "conclusion": "neutral"},
+				{"status": "completed", "conclusion": "skipped"}]}`,
+			want: "success",
+		},
+		{
+			name:   "check run in progress",
+			status: `{"state": "success", "total_count": 1}`,
+			checkRuns: `{"check_runs": [
+				{"status": "in_progress"}]}`,
+			want: "pending",
+		},
+		{
+			name:   "status pending",
+			status: `{"state": "pending", "total_count": 1}`,
+			checkRuns: `{"check_runs": [
+				{"status": "completed", "conclusion": "success"}]}`,
+			want: "pending",
+		},
+		{
+			name:   "check run failed",
+			status: `{"state": "success", "total_count": 1}`,
+			checkRuns: `{"check_runs": [
+				{"status": "in_progress"},
+				{"status": "completed", "conclusion": "timed_out"}]}`,
+			want: "failure",
+		},
+		{
+			name:      "status errored",
+			status:    `{"state": "error", "total_count": 1}`,
+			checkRuns: noRuns,
+			want:      "failure",
+		},
+	}
+
+	for _, test := range tests {
+		mux := http.NewServeMux()
+		serveJSON(mux, "/repos/kelda/bot/commits/abc/status", test.status)
+		serveJSON(mux, "/repos/kelda/bot/commits/abc/check-runs",
+			test.checkRuns)
+		client, closeGitHub := newFakeGitHub(mux)
+		got, err := commitChecksState(client, "bot", "abc")
+		closeGitHub()
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
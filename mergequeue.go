@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// queuedPR is a pull request waiting in a repository's merge queue.
+type queuedPR struct {
+	Number     int       `json:"number"`
+	Title      string    `json:"title"`
+	Author     string    `json:"author"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+
+	// UpdateRequestedFor is the head commit for which the bot last asked
+	// GitHub to merge in the base branch. It's used to wait for that
+	// update rather than requesting it again.
+	UpdateRequestedFor string    `json:"updateRequestedFor,omitempty"`
+	UpdateRequestedAt  time.Time `json:"updateRequestedAt,omitempty"`
+}
+
+// statusGracePeriod is how long the merge queue waits for status checks to be
+// reported on a pull request it updated, before assuming the repository
+// doesn't have any.
+const statusGracePeriod = 5 * time.Minute
+
+// mergeQueueLock serializes processing of the merge queues, so that only one
+// pull request per repository is being updated or merged at a time.
+var mergeQueueLock sync.Mutex
+
+// enqueuePR adds an approved pull request to its repository's merge queue, if
+// it isn't already queued and wasn't ejected from the queue at its current
+// head commit.
+func enqueuePR(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest) {
+	repo := *pr.Base.Repo.Name
+	position := 0
+	updateState(func(s *botState) {
+		if s.MergeQueueEjected[prKey(pr)] == pr.Head.GetSHA() {
+			return
+		}
+		for _, queued := range s.MergeQueues[repo] {
+			if queued.Number == *pr.Number {
+				return
+			}
+		}
+
+		if s.MergeQueues == nil {
+			s.MergeQueues = map[string][]queuedPR{}
+		}
+		s.MergeQueues[repo] = append(s.MergeQueues[repo], queuedPR{
+			Number:     *pr.Number,
+			Title:      pr.GetTitle(),
+			Author:     *pr.User.Login,
+			EnqueuedAt: time.Now(),
+		})
+		position = len(s.MergeQueues[repo])
+	})
+	if position == 0 {
+		return
+	}
+
+	log.Infof("Added %s to the merge queue at position %d", prKey(pr), position)
+	commentOnPR(client, pr, fmt.Sprintf("This pull request is approved, so "+
+		"I added it to the merge queue at position %d. I'll update it with "+
+		"`%s`, wait for its status checks, and merge it.",
+		position, pr.Base.GetRef()))
+	advanceMergeQueue(client, slackClient, repo)
+}
+
+// advanceMergeQueue moves the given repository's merge queue along as far as
+// possible. The pull request at the front of the queue is brought up to date
+// with its base branch, and merged once its status checks pass. It's called
+// whenever something happens that might let the queue make progress, such as
+// a status check finishing.
+func advanceMergeQueue(client *github.Client, slackClient *slack.Client,
+	repo string) {
+	mergeQueueLock.Lock()
+	defer mergeQueueLock.Unlock()
+
+	for {
+		var front *queuedPR
+		viewState(func(s *botState) {
+			if queue := s.MergeQueues[repo]; len(queue) > 0 {
+				queued := queue[0]
+				front = &queued
+			}
+		})
+		if front == nil {
+			return
+		}
+
+		pr, _, err := client.PullRequests.Get(ctx(), "kelda", repo, front.Number)
+		if err != nil {
+			log.WithError(err).Warnf("unable to get queued PR %s#%d",
+				repo, front.Number)
+			return
+		}
+		if pr.GetState() != "open" {
+			log.Infof("Removing closed PR %s from the merge queue", prKey(pr))
+			dequeuePR(repo, front.Number)
+			continue
+		}
+
+		comparison, _, err := client.Repositories.CompareCommits(ctx(),
+			"kelda", repo, pr.Base.GetRef(), pr.Head.GetSHA())
+		if err != nil {
+			log.WithError(err).Warnf("unable to compare %s with its base",
+				prKey(pr))
+			return
+		}
+		if comparison.GetBehindBy() > 0 {
+			if front.UpdateRequestedFor == pr.Head.GetSHA() {
+				// GitHub is still merging in the base branch.
+				return
+			}
+			if err := updatePRBranch(client, pr); err != nil {
+				ejectPR(client, slackClient, pr, fmt.Sprintf(
+					"I couldn't update it with `%s`: %s",
+					pr.Base.GetRef(), err))
+				continue
+			}
+			updateState(func(s *botState) {
+				if queue := s.MergeQueues[repo]; len(queue) > 0 &&
+					queue[0].Number == front.Number {
+					queue[0].UpdateRequestedFor = pr.Head.GetSHA()
+					queue[0].UpdateRequestedAt = time.Now()
+				}
+			})
+			return
+		}
+
+		state, err := commitChecksState(client, repo, pr.Head.GetSHA())
+		if err != nil {
+			log.WithError(err).Warnf("unable to get status of %s", prKey(pr))
+			return
+		}
+		switch {
+		case state == "" &&
+			time.Since(front.UpdateRequestedAt) < statusGracePeriod:
+			return
+		case state == "" || state == "success":
+		case state == "pending":
+			return
+		default:
+			ejectPR(client, slackClient, pr, fmt.Sprintf(
+				"its status checks failed after it was updated with `%s`",
+				pr.Base.GetRef()))
+			continue
+		}
+
+		// The approval may have been withdrawn, or changes requested,
+		// while the PR waited in the queue.
+		approved, err := isCommitterApproved(client, pr)
+		if err != nil {
+			log.WithError(err).Warnf("unable to get reviews of %s",
+				prKey(pr))
+			return
+		}
+		if !approved {
+			ejectPR(client, slackClient, pr,
+				"it's no longer approved by a committer")
+			continue
+		}
+
+		if err := mergePR(client, pr); err != nil {
+			ejectPR(client, slackClient, pr, fmt.Sprintf(
+				"I couldn't merge it: %s", err))
+			continue
+		}
+		dequeuePR(repo, front.Number)
+		if config.MergeChannel != "" {
+			postToChannel(slackClient, config.MergeChannel, fmt.Sprintf(
+				":tada: %s by %s made it through the merge queue.",
+				prLink(pr), *pr.User.Login))
+		}
+		notifyUser(slackClient, *pr.User.Login, fmt.Sprintf(
+			"%s made it through the merge queue and was merged.",
+			prLink(pr)))
+	}
+}
+
+// advanceAllMergeQueues advances the merge queue of every repository that has
+// one, in case a webhook event was missed.
+func advanceAllMergeQueues(client *github.Client, slackClient *slack.Client) {
+	var repos []string
+	viewState(func(s *botState) {
+		for repo, queue := range s.MergeQueues {
+			if len(queue) > 0 {
+				repos = append(repos, repo)
+			}
+		}
+	})
+	for _, repo := range repos {
+		advanceMergeQueue(client, slackClient, repo)
+	}
+}
+
+// updatePRBranch asks GitHub to merge the PR's base branch into its head
+// branch. The update happens asynchronously.
+func updatePRBranch(client *github.Client, pr *github.PullRequest) error {
+	log.Infof("Updating %s with %s", prKey(pr), pr.Base.GetRef())
+	body := map[string]string{"expected_head_sha": pr.Head.GetSHA()}
+	return prRequest(client, pr, "PUT", "update-branch", &body, nil)
+}
+
+// dequeuePR removes the given pull request from its repository's merge queue.
+func dequeuePR(repo string, number int) {
+	updateState(func(s *botState) {
+		var remaining []queuedPR
+		for _, queued := range s.MergeQueues[repo] {
+			if queued.Number != number {
+				remaining = append(remaining, queued)
+			}
+		}
+		s.MergeQueues[repo] = remaining
+	})
+}
+
+// ejectPR removes a pull request from the merge queue because it couldn't be
+// merged, and tells its author why. The PR isn't queued again until its head
+// commit changes.
+func ejectPR(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest, reason string) {
+	log.Infof("Ejecting %s from the merge queue: %s", prKey(pr), reason)
+	dequeuePR(*pr.Base.Repo.Name, *pr.Number)
+	updateState(func(s *botState) {
+		if s.MergeQueueEjected == nil {
+			s.MergeQueueEjected = map[string]string{}
+		}
+		s.MergeQueueEjected[prKey(pr)] = pr.Head.GetSHA()
+	})
+
+	message := fmt.Sprintf("I removed this pull request from the merge "+
+		"queue because %s. Once that's fixed, push a new commit and it will "+
+		"be queued again.", reason)
+	commentOnPR(client, pr, fmt.Sprintf("@%s %s", *pr.User.Login, message))
+	notifyUser(slackClient, *pr.User.Login, fmt.Sprintf(
+		"I removed %s from the merge queue because %s.", prLink(pr), reason))
+}
+
+// queueCommand handles "queue [REPO]", which lists the pull requests in a
+// merge queue. On a pull request, it defaults to that PR's repository; in
+// Slack, it lists every queue if no repository is given.
+func queueCommand(client *github.Client, req commandRequest) (string, error) {
+	var repos []string
+	switch {
+	case len(req.args) > 1:
+		repos = []string{req.args[1]}
+	case req.pr != nil:
+		repos = []string{*req.pr.Base.Repo.Name}
+	default:
+		for repo, repoConfig := range config.Repos {
+			if repoConfig.MergeQueue {
+				repos = append(repos, repo)
+			}
+		}
+		sort.Strings(repos)
+	}
+	if len(repos) == 0 {
+		return "", errors.New("no repositories use the merge queue")
+	}
+
+	var lines []string
+	viewState(func(s *botState) {
+		for _, repo := range repos {
+			queue := s.MergeQueues[repo]
+			if len(queue) == 0 {
+				lines = append(lines, fmt.Sprintf(
+					"The %s merge queue is empty.", repo))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("The %s merge queue:", repo))
+			for i, queued := range queue {
+				lines = append(lines, fmt.Sprintf(
+					"%d. #%d %s (by %s, queued %s ago)", i+1,
+					queued.Number, queued.Title, queued.Author,
+					time.Since(queued.EnqueuedAt).Truncate(time.Minute)))
+			}
+		}
+	})
+	return strings.Join(lines, "\n"), nil
+}
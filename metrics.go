@@ -48,6 +48,7 @@ func recordMetrics(githubClient *github.Client, googleClient *sheets.Service,
 	recordDailyGithubClones(
 		githubInstallRepo, clonesInstallSheetName, githubClient, googleClient)
 	recordTotalData(githubClient, googleClient, slackClient)
+	recordReleaseDownloads(githubClient, googleClient)
 }
 
 // newGoogleClient creates a new client to use to read and write Google Sheets data.
@@ -62,6 +63,46 @@ func newGoogleClient(secretJSON []byte) (*sheets.Service, error) {
 	return sheets.New(httpClient)
 }
 
+// ensureSheet adds a sheet with the given name and header row to the
+// spreadsheet, if it doesn't already have one.
+func ensureSheet(googleClient *sheets.Service, sheetName string,
+	header []interface{}) error {
+	spreadsheet, err := googleClient.Spreadsheets.Get(googleSpreadsheetID).Do()
+	if err != nil {
+		return err
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == sheetName {
+			return nil
+		}
+	}
+
+	add := sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			AddSheet: &sheets.AddSheetRequest{
+				Properties: &sheets.SheetProperties{Title: sheetName},
+			},
+		}},
+	}
+	_, err = googleClient.Spreadsheets.BatchUpdate(googleSpreadsheetID, &add).Do()
+	if err != nil {
+		return err
+	}
+	return appendRows(googleClient, sheetName, [][]interface{}{header})
+}
+
+// appendRows adds the given rows after the last row of the given sheet.
+func appendRows(googleClient *sheets.Service, sheetName string,
+	rows [][]interface{}) error {
+	write := sheets.ValueRange{Values: rows}
+	_, err := googleClient.Spreadsheets.Values.Append(googleSpreadsheetID,
+		fmt.Sprintf("%s!A1", sheetName), &write).
+		ValueInputOption("USER_ENTERED").
+		InsertDataOption("INSERT_ROWS").
+		Do()
+	return err
+}
+
 // getPresentedToFormula returns a formula that calculates the total number
 // of people presented to up to and including the date in column A of the
 // given row.
@@ -130,9 +171,8 @@ func updateDailyTrafficData(
 			googleSpreadsheetID, writeRange, &write)
 		_, err := updateCall.ValueInputOption("USER_ENTERED").Do()
 		if err != nil {
-			errMsg := fmt.Sprintf(
-				"unable to update range %s in sheet:", writeRange)
-			log.WithError(err).Warnf(errMsg)
+			log.WithError(err).Warnf(
+				"unable to update range %s in sheet", writeRange)
 		}
 	}
 }
@@ -166,8 +206,7 @@ func recordDailyGithubClones(
 }
 
 func getTotalReleaseDownloads(githubClient *github.Client) int {
-	releases, _, err := githubClient.Repositories.ListReleases(
-		ctx(), githubOwner, githubRepo, nil)
+	releases, err := listReleases(githubClient, githubRepo)
 	if err != nil {
 		log.WithError(err).Warnf("unable to list github repositories")
 		return errorValue
@@ -313,7 +352,7 @@ func recordTotalData(
 		googleSpreadsheetID, writeRange, &write)
 	_, err = u.ValueInputOption("USER_ENTERED").Do()
 	if err != nil {
-		log.WithError(err).Warnf(fmt.Sprintf(
-			"unable to update range %s in sheet", writeRange))
+		log.WithError(err).Warnf(
+			"unable to update range %s in sheet", writeRange)
 	}
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+)
+
+// queuedNotification is a direct message that's being held until its
+// recipient's Do Not Disturb period or time off ends.
+type queuedNotification struct {
+	Login       string             `json:"login"`
+	Text        string             `json:"text"`
+	Attachments []slack.Attachment `json:"attachments,omitempty"`
+	SendAt      time.Time          `json:"sendAt"`
+
+	// CIFailure is the key in botState.CIFailures of the CI failure
+	// notification this is, if any, so that its thread can be recorded
+	// once it's sent.
+	CIFailure string `json:"ciFailure,omitempty"`
+}
+
+// notifyUser sends a direct message on Slack to the given GitHub user, and
+// returns the channel and timestamp of the message. If the user is in Do Not
+// Disturb, the message is queued and sent once their DND period ends, and
+// empty strings are returned.
+func notifyUser(slackClient *slack.Client, login, text string,
+	attachments ...slack.Attachment) (channel, timestamp string) {
+	return sendNotification(slackClient, queuedNotification{
+		Login:       login,
+		Text:        text,
+		Attachments: attachments,
+	})
+}
+
+// sendNotification sends the given notification like notifyUser, queuing it
+// if its recipient is in Do Not Disturb.
+func sendNotification(slackClient *slack.Client, n queuedNotification) (
+	channel, timestamp string) {
+	slackID := config.Users[n.Login].Slack
+	if slackID == "" {
+		log.Debugf("not notifying %s, who has no Slack ID configured",
+			n.Login)
+		return "", ""
+	}
+
+	if until := dndEnd(slackClient, slackID); until.After(time.Now()) {
+		log.Infof("%s is in Do Not Disturb; queuing notification until %s",
+			n.Login, until)
+		n.SendAt = until
+		updateState(func(s *botState) {
+			s.Notifications = append(s.Notifications, n)
+		})
+		return "", ""
+	}
+
+	return sendDirectMessage(slackClient, slackID, n.Text, n.Attachments)
+}
+
+// notifyDuringWorkingHours sends a direct message like notifyUser, except that
+// messages to people outside of their working hours are queued until their
+// working day starts.
+func notifyDuringWorkingHours(slackClient *slack.Client, login, text string,
+	attachments ...slack.Attachment) {
+	now := time.Now()
+	loc := userLocation(slackClient, login)
+	if inWorkingHours(now, loc) || config.Users[login].Slack == "" {
+		notifyUser(slackClient, login, text, attachments...)
+		return
+	}
+
+	sendAt := nextWorkingTime(now, loc)
+	log.Infof("%s is outside of working hours; queuing notification until %s",
+		login, sendAt)
+	updateState(func(s *botState) {
+		s.Notifications = append(s.Notifications, queuedNotification{
+			Login:       login,
+			Text:        text,
+			Attachments: attachments,
+			SendAt:      sendAt,
+		})
+	})
+}
+
+// sendQueuedNotifications sends all of the queued notifications whose
+// recipients are no longer in Do Not Disturb.
+func sendQueuedNotifications(slackClient *slack.Client) {
+	now := time.Now()
+	anyDue := false
+	viewState(func(s *botState) {
+		for _, n := range s.Notifications {
+			anyDue = anyDue || !n.SendAt.After(now)
+		}
+	})
+	if !anyDue {
+		return
+	}
+
+	var due []queuedNotification
+	updateState(func(s *botState) {
+		var waiting []queuedNotification
+		for _, n := range s.Notifications {
+			if n.SendAt.After(now) {
+				waiting = append(waiting, n)
+			} else {
+				due = append(due, n)
+			}
+		}
+		s.Notifications = waiting
+	})
+
+	// Go through notifyUser again rather than sending directly, in case
+	// the recipient snoozed again in the meantime.
+	for _, n := range due {
+		channel, timestamp := sendNotification(slackClient, n)
+		if n.CIFailure != "" && channel != "" {
+			recordCIThread(n.CIFailure, channel, timestamp)
+		}
+	}
+}
+
+// dndEnd returns when the given Slack user's current Do Not Disturb period
+// ends, or the zero time if they aren't in Do Not Disturb.
+func dndEnd(slackClient *slack.Client, slackID string) time.Time {
+	dnd, err := slackClient.GetDNDInfo(&slackID)
+	if err != nil {
+		log.WithError(err).Warnf("unable to get DND status of %s", slackID)
+		return time.Time{}
+	}
+
+	now := time.Now()
+	var end time.Time
+	if dnd.SnoozeEnabled {
+		end = time.Unix(int64(dnd.SnoozeEndTime), 0)
+	}
+	start := time.Unix(int64(dnd.NextStartTimestamp), 0)
+	scheduledEnd := time.Unix(int64(dnd.NextEndTimestamp), 0)
+	if dnd.Enabled && !now.Before(start) && scheduledEnd.After(end) {
+		end = scheduledEnd
+	}
+	return end
+}
+
+// sendDirectMessage sends a Slack message directly to the given Slack user,
+// and returns the channel and timestamp of the message.
+func sendDirectMessage(slackClient *slack.Client, slackID, text string,
+	attachments []slack.Attachment) (channel, timestamp string) {
+	_, _, channel, err := slackClient.OpenIMChannel(slackID)
+	if err != nil {
+		log.WithError(err).Warnf("unable to open a DM with %s", slackID)
+		return "", ""
+	}
+
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.Attachments = attachments
+	channel, timestamp, err = slackClient.PostMessage(channel, text, params)
+	if err != nil {
+		log.WithError(err).Warnf("unable to send a DM to %s", slackID)
+	}
+	return channel, timestamp
+}
+
+// isActiveOnSlack returns whether the given GitHub user is currently active on
+// Slack. It returns false if the user's Slack ID isn't known.
+func isActiveOnSlack(slackClient *slack.Client, login string) bool {
+	slackID := config.Users[login].Slack
+	if slackID == "" {
+		return false
+	}
+	presence, err := slackClient.GetUserPresence(slackID)
+	if err != nil {
+		log.WithError(err).Warnf("unable to get Slack presence of %s", login)
+		return false
+	}
+	return presence.Presence == "active"
+}
+
+// slackMention returns the Slack mention of the given GitHub user, or their
+// login if they have no Slack ID configured.
+func slackMention(login string) string {
+	if slackID := config.Users[login].Slack; slackID != "" {
+		return "<@" + slackID + ">"
+	}
+	return login
+}
+
+// postToChannel posts a message to the given Slack channel, and returns the
+// message's timestamp, or the empty string if posting failed.
+func postToChannel(slackClient *slack.Client, channel, text string,
+	attachments ...slack.Attachment) string {
+	return postThreadReply(slackClient, channel, "", text, attachments...)
+}
+
+// postThreadReply posts a message in the thread under the message with the
+// given timestamp in a Slack channel, and returns the reply's timestamp. If
+// threadTimestamp is empty, the message is posted at the top level of the
+// channel.
+func postThreadReply(slackClient *slack.Client, channel, threadTimestamp,
+	text string, attachments ...slack.Attachment) string {
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	params.ThreadTimestamp = threadTimestamp
+	params.Attachments = attachments
+	_, timestamp, err := slackClient.PostMessage(channel, text, params)
+	if err != nil {
+		log.WithError(err).Warnf("unable to post to Slack channel %s", channel)
+	}
+	return timestamp
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDNDEnd(t *testing.T) {
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour).Unix()
+	inAnHour := now.Add(time.Hour).Unix()
+	inTwoHours := now.Add(2 * time.Hour).Unix()
+	tests := []struct {
+		name     string
+		response string
+		want     int64
+	}{
+		{
+			name:     "not in DND",
+			response: `{"ok": true, "dnd_enabled": false}`,
+		},
+		{
+			name: "snoozed",
+			response: fmt.Sprintf(`{"ok": true, "snooze_enabled": true, `+
+				`"snooze_endtime": %d}`, inAnHour),
+			want: inAnHour,
+		},
+		{
+			name: "in scheduled DND",
+			response: fmt.Sprintf(`{"ok": true, "dnd_enabled": true, `+
+				`"next_dnd_start_ts": %d, "next_dnd_end_ts": %d}`,
+				hourAgo, inAnHour),
+			want: inAnHour,
+		},
+		{
+			name: "before scheduled DND",
+			response: fmt.Sprintf(`{"ok": true, "dnd_enabled": true, `+
+				`"next_dnd_start_ts": %d, "next_dnd_end_ts": %d}`,
+				inAnHour, inTwoHours),
+		},
+		{
+			name: "snoozed past scheduled DND",
+			response: fmt.Sprintf(`{"ok": true, "snooze_enabled": true, `+
+				`"snooze_endtime": %d, "dnd_enabled": true, `+
+				`"next_dnd_start_ts": %d, "next_dnd_end_ts": %d}`,
+				inTwoHours, hourAgo, inAnHour),
+			want: inTwoHours,
+		},
+		{
+			name:     "error",
+			response: `{"ok": false, "error": "user_not_found"}`,
+		},
+	}
+
+	for _, test := range tests {
+		var gotUser string
+		slackClient, closeSlack := newFakeSlack(
+			func(method string, args url.Values) string {
+				gotUser = args.Get("user")
+				return test.response
+			})
+		got := dndEnd(slackClient, "U123")
+		closeSlack()
+
+		if gotUser != "U123" {
+			t.Errorf("%s: asked about %q, want U123", test.name, gotUser)
+		}
+		want := time.Time{}
+		if test.want != 0 {
+			want = time.Unix(test.want, 0)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%s: got %s, want %s", test.name, got, want)
+		}
+	}
+}
+
+func TestQueuedCIFailureThread(t *testing.T) {
+	defer useTestState(t)()
+	config = botConfig{Users: map[string]userConfig{"ann": {Slack: "U1"}}}
+	defer func() { config = botConfig{} }()
+
+	key := ciFailureKey("abc", "bot#1")
+	failure := ciFailure{PR: "bot#1", Author: "ann",
+		Failing: map[string]string{"lint": ""}}
+	state.CIFailures = map[string]ciFailure{key: failure}
+	state.Notifications = []queuedNotification{{
+		Login:     "ann",
+		Text:      "failed",
+		SendAt:    time.Now().Add(-time.Minute),
+		CIFailure: key,
+	}}
+
+	var posts []url.Values
+	slackClient, closeSlack := newFakeSlack(
+		func(method string, args url.Values) string {
+			switch method {
+			case "im.open":
+				return `{"ok": true, "channel": {"id": "D1"}}`
+			case "chat.postMessage":
+				posts = append(posts, args)
+				return `{"ok": true, "channel": "D1", "ts": "1.5"}`
+			}
+			return `{"ok": true}`
+		})
+	defer closeSlack()
+
+	// While the notification is queued, replies are added to it.
+	replyAboutCI(slackClient, key, failure, "still failing")
+	if len(posts) != 0 {
+		t.Errorf("posted %d messages while queued, want 0", len(posts))
+	}
+
+	sendQueuedNotifications(slackClient)
+	if len(posts) != 1 || posts[0].Get("text") != "failed\nstill failing" {
+		t.Fatalf("got posts %v, want the queued notification", posts)
+	}
+	if got := state.CIFailures[key]; got.Channel != "D1" ||
+		got.Timestamp != "1.5" {
+		t.Errorf("got thread %s %s, want D1 1.5", got.Channel,
+			got.Timestamp)
+	}
+
+	// Once it's sent, replies go in its thread, even given the failure
+	// as it was before.
+	replyAboutCI(slackClient, key, failure, "passing")
+	if len(posts) != 2 || posts[1].Get("thread_ts") != "1.5" {
+		t.Errorf("got posts %v, want a reply in the thread", posts)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// presentationsSheetName is the sheet that getPresentedToFormula sums.
+var presentationsSheetName = "Presentations"
+
+// The headers of the presentations sheet's columns. The sheet is filled in by
+// hand too, so its columns are found by header rather than by position. Only
+// the audience column is required.
+const (
+	presentationDateHeader      = "Date"
+	presentationEventHeader     = "Event"
+	presentationPresenterHeader = "Presenter"
+	presentationAudienceHeader  = "Audience"
+)
+
+// presentedPattern matches messages like "presented 40 people at KubeCon on
+// 2026-10-12". The date is optional, and defaults to today.
+var presentedPattern = regexp.MustCompile(`(?i)^presented\s+(?:to\s+)?(\d+)\s+` +
+	`(?:people\s+)?at\s+(.+?)(?:\s+on\s+(\d{4}-\d{2}-\d{2}))?\.?$`)
+
+// logPresentation parses a message saying how many people login presented to,
+// adds it to the presentations sheet, and returns a reply with the running
+// total.
+func logPresentation(googleClient *sheets.Service, login, text string) (
+	string, error) {
+	match := presentedPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return "", errors.New("usage: presented 40 people at EVENT " +
+			"[on YYYY-MM-DD]")
+	}
+	audience, err := strconv.Atoi(match[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid audience size: %s", err)
+	}
+	event := match[2]
+	when := time.Now()
+	if match[3] != "" {
+		d, err := parseDate(match[3])
+		if err != nil {
+			return "", fmt.Errorf("invalid date: %s", err)
+		}
+		when = d.Time
+	}
+
+	columns, err := presentationColumns(googleClient)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read the presentations sheet: %s", err)
+	}
+	row := presentationRow(columns, map[string]interface{}{
+		presentationDateHeader:      when.Format(timeFormat),
+		presentationEventHeader:     event,
+		presentationPresenterHeader: login,
+		presentationAudienceHeader:  audience,
+	})
+	err = appendRows(googleClient, presentationsSheetName, [][]interface{}{row})
+	if err != nil {
+		return "", fmt.Errorf("couldn't add the presentation to the "+
+			"sheet: %s", err)
+	}
+
+	total, err := totalPresentedTo(googleClient,
+		columns[presentationAudienceHeader])
+	if err != nil {
+		return fmt.Sprintf("Thanks! I logged %d people at %s on %s.",
+			audience, event, when.Format(dateFormat)), nil
+	}
+	return fmt.Sprintf("Thanks! I logged %d people at %s on %s. We've "+
+		"presented to %d people in total.", audience, event,
+		when.Format(dateFormat), total), nil
+}
+
+// presentationColumns returns the index of each column of the presentations
+// sheet, keyed by header. The header row is the last row above the sheet's
+// content that has an audience column. Headers are matched case-insensitively.
+func presentationColumns(googleClient *sheets.Service) (map[string]int, error) {
+	readRange := fmt.Sprintf("%s!1:%d", presentationsSheetName,
+		firstContentRow-1)
+	resp, err := googleClient.Spreadsheets.Values.Get(
+		googleSpreadsheetID, readRange).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []string{presentationDateHeader, presentationEventHeader,
+		presentationPresenterHeader, presentationAudienceHeader}
+	for i := len(resp.Values) - 1; i >= 0; i-- {
+		columns := map[string]int{}
+		for column, cell := range resp.Values[i] {
+			for _, header := range headers {
+				if strings.EqualFold(strings.TrimSpace(fmt.Sprint(cell)),
+					header) {
+					columns[header] = column
+				}
+			}
+		}
+		if _, ok := columns[presentationAudienceHeader]; ok {
+			return columns, nil
+		}
+	}
+	return nil, fmt.Errorf("no %q column", presentationAudienceHeader)
+}
+
+// presentationRow returns a row of the presentations sheet with the given
+// values, keyed by header, in their columns. Values without a column are
+// dropped.
+func presentationRow(columns map[string]int,
+	values map[string]interface{}) []interface{} {
+	width := 0
+	for _, column := range columns {
+		if column >= width {
+			width = column + 1
+		}
+	}
+	row := make([]interface{}, width)
+	for i := range row {
+		row[i] = ""
+	}
+	for header, value := range values {
+		if column, ok := columns[header]; ok {
+			row[column] = value
+		}
+	}
+	return row
+}
+
+// totalPresentedTo returns the total number of people in the given audience
+// column of the presentations sheet.
+func totalPresentedTo(googleClient *sheets.Service, column int) (int, error) {
+	letter := columnLetter(column)
+	readRange := fmt.Sprintf("%s!%s%d:%s", presentationsSheetName, letter,
+		firstContentRow, letter)
+	resp, err := googleClient.Spreadsheets.Values.Get(
+		googleSpreadsheetID, readRange).Do()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, row := range resp.Values {
+		if len(row) == 0 {
+			continue
+		}
+		value := strings.Replace(fmt.Sprint(row[0]), ",", "", -1)
+		if n, err := strconv.Atoi(value); err == nil {
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// columnLetter returns the A1 notation letters of the column with the given
+// zero-based index.
+func columnLetter(column int) string {
+	letters := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		letters = string(rune('A'+(column-1)%26)) + letters
+	}
+	return letters
+}
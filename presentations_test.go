@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPresentedPattern(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{
+			text: "presented 40 people at KubeCon",
+			want: []string{"40", "KubeCon", ""},
+		},
+		{
+			text: "Presented to 12 at the Go meetup on 2026-10-12.",
+			want: []string{"12", "the Go meetup", "2026-10-12"},
+		},
+		{
+			text: "presented 5 people at a talk on containers",
+			want: []string{"5", "a talk on containers", ""},
+		},
+		{
+			text: "presented at KubeCon",
+			want: nil,
+		},
+		{
+			text: "I presented 40 people at KubeCon",
+			want: nil,
+		},
+	}
+
+	for _, test := range tests {
+		var got []string
+		if match := presentedPattern.FindStringSubmatch(test.text); match != nil {
+			got = match[1:]
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestPresentationRow(t *testing.T) {
+	columns := map[string]int{
+		presentationDateHeader:     1,
+		presentationAudienceHeader: 3,
+	}
+	got := presentationRow(columns, map[string]interface{}{
+		presentationDateHeader:      "2026-10-12",
+		presentationPresenterHeader: "ann",
+		presentationAudienceHeader:  40,
+	})
+	want := []interface{}{"", "2026-10-12", "", 40}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestColumnLetter(t *testing.T) {
+	tests := map[int]string{0: "A", 3: "D", 25: "Z", 26: "AA", 27: "AB",
+		701: "ZZ", 702: "AAA"}
+	for column, want := range tests {
+		if got := columnLetter(column); got != want {
+			t.Errorf("%d: got %q, want %q", column, got, want)
+		}
+	}
+}
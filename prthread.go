@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// Reactions added to a pull request's Slack message to show its review state
+// at a glance.
+const (
+	approvedReaction         = "white_check_mark"
+	changesRequestedReaction = "exclamation"
+)
+
+// prThread is the Slack message that mirrors the activity on a pull request.
+// The message shows the PR's current state, and each event is posted in its
+// thread.
+type prThread struct {
+	Channel   string `json:"channel"`
+	Timestamp string `json:"timestamp"`
+
+	// Status is the PR's current state, as shown in the message.
+	Status string `json:"status"`
+
+	// Reaction is the reaction currently on the message, if any.
+	Reaction string `json:"reaction,omitempty"`
+
+	// Reviewed lists the people who have submitted reviews, so that
+	// review requests to them can be recognized as re-requests.
+	Reviewed []string `json:"reviewed,omitempty"`
+}
+
+// startPRThread posts the Slack message for a pull request that was just
+// assigned its first reviewer, in its repository's channel. Nothing is posted
+// if the repository has no channel, or the PR already has a message.
+func startPRThread(slackClient *slack.Client, pr *github.PullRequest,
+	reviewer string) {
+	channel := config.Repos[*pr.Base.Repo.Name].SlackChannel
+	if channel == "" || getPRThread(pr) != nil {
+		return
+	}
+
+	status := fmt.Sprintf("Waiting for a review from %s", reviewer)
+	params := slack.NewPostMessageParameters()
+	params.AsUser = true
+	channelID, timestamp, err := slackClient.PostMessage(channel,
+		prThreadText(pr, status), params)
+	if err != nil {
+		log.WithError(err).Warnf("unable to post the Slack thread for %s",
+			prKey(pr))
+		return
+	}
+	updateState(func(s *botState) {
+		if s.PRThreads == nil {
+			s.PRThreads = map[string]prThread{}
+		}
+		s.PRThreads[prKey(pr)] = prThread{
+			Channel:   channelID,
+			Timestamp: timestamp,
+			Status:    status,
+		}
+	})
+}
+
+// getPRThread returns the Slack message for the given pull request, or nil if
+// it doesn't have one.
+func getPRThread(pr *github.PullRequest) *prThread {
+	var thread *prThread
+	viewState(func(s *botState) {
+		if t, ok := s.PRThreads[prKey(pr)]; ok {
+			thread = &t
+		}
+	})
+	return thread
+}
+
+// prThreadText returns the text of the Slack message for a pull request.
+func prThreadText(pr *github.PullRequest, status string) string {
+	return fmt.Sprintf("*%s* by %s\n%s", prLink(pr), *pr.User.Login, status)
+}
+
+// postToPRThread posts a message in the Slack thread of the pull request with
+// the given prKey, if it has one.
+func postToPRThread(slackClient *slack.Client, key, text string) {
+	var thread *prThread
+	viewState(func(s *botState) {
+		if t, ok := s.PRThreads[key]; ok {
+			thread = &t
+		}
+	})
+	if thread != nil {
+		postThreadReply(slackClient, thread.Channel, thread.Timestamp, text)
+	}
+}
+
+// setPRThreadStatus updates the state shown in the Slack message of the given
+// pull request, and replaces its reaction with the given one. An empty
+// reaction removes the current reaction.
+func setPRThreadStatus(slackClient *slack.Client, pr *github.PullRequest,
+	status, reaction string) {
+	thread := getPRThread(pr)
+	if thread == nil {
+		return
+	}
+
+	_, _, _, err := slackClient.UpdateMessage(thread.Channel, thread.Timestamp,
+		prThreadText(pr, status))
+	if err != nil {
+		log.WithError(err).Warnf("unable to update the Slack thread for %s",
+			prKey(pr))
+	}
+
+	ref := slack.NewRefToMessage(thread.Channel, thread.Timestamp)
+	if thread.Reaction != reaction {
+		if thread.Reaction != "" {
+			if err := slackClient.RemoveReaction(thread.Reaction, ref); err != nil {
+				log.WithError(err).Warnf("unable to remove reaction "+
+					"from the Slack thread for %s", prKey(pr))
+			}
+		}
+		if reaction != "" {
+			if err := slackClient.AddReaction(reaction, ref); err != nil {
+				log.WithError(err).Warnf("unable to react to the "+
+					"Slack thread for %s", prKey(pr))
+			}
+		}
+	}
+
+	updateState(func(s *botState) {
+		if t, ok := s.PRThreads[prKey(pr)]; ok {
+			t.Status = status
+			t.Reaction = reaction
+			s.PRThreads[prKey(pr)] = t
+		}
+	})
+}
+
+// threadReview mirrors a submitted review in the Slack thread of its pull
+// request.
+func threadReview(slackClient *slack.Client, event *github.PullRequestReviewEvent) {
+	pr := event.PullRequest
+	thread := getPRThread(pr)
+	if event.GetAction() != "submitted" || thread == nil {
+		return
+	}
+
+	reviewer := event.Review.User.GetLogin()
+	updateState(func(s *botState) {
+		t := s.PRThreads[prKey(pr)]
+		if !userInList(&reviewer, t.Reviewed) {
+			t.Reviewed = append(t.Reviewed, reviewer)
+			s.PRThreads[prKey(pr)] = t
+		}
+	})
+
+	link := fmt.Sprintf("<%s|review>", event.Review.GetHTMLURL())
+	switch strings.ToUpper(event.Review.GetState()) {
+	case "APPROVED":
+		postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+			":white_check_mark: %s approved (%s).", reviewer, link))
+		setPRThreadStatus(slackClient, pr,
+			fmt.Sprintf("Approved by %s", reviewer), approvedReaction)
+	case "CHANGES_REQUESTED":
+		postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+			":exclamation: %s requested changes (%s).", reviewer, link))
+		setPRThreadStatus(slackClient, pr,
+			fmt.Sprintf("Changes requested by %s", reviewer),
+			changesRequestedReaction)
+	default:
+		postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+			":speech_balloon: %s commented (%s).", reviewer, link))
+	}
+}
+
+// threadPREvent mirrors re-requested reviews and the closing of a pull request
+// in its Slack thread. payload is the raw webhook payload of event.
+func threadPREvent(slackClient *slack.Client, event *github.PullRequestEvent,
+	payload []byte) {
+	pr := event.PullRequest
+	thread := getPRThread(pr)
+	if thread == nil {
+		return
+	}
+
+	switch event.GetAction() {
+	case "review_requested":
+		reviewer := requestedReviewer(payload)
+		if reviewer == "" {
+			return
+		}
+		if !userInList(&reviewer, thread.Reviewed) {
+			// First requests are posted when they're made.
+			return
+		}
+		postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+			":repeat: %s asked %s to review again.",
+			event.Sender.GetLogin(), reviewer))
+		setPRThreadStatus(slackClient, pr,
+			fmt.Sprintf("Waiting for a review from %s", reviewer), "")
+	case "closed":
+		if pr.GetMerged() {
+			postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+				":tada: Merged by %s.", pr.MergedBy.GetLogin()))
+			setPRThreadStatus(slackClient, pr, "Merged", "tada")
+		} else {
+			postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+				":no_entry_sign: Closed by %s without merging.",
+				event.Sender.GetLogin()))
+			setPRThreadStatus(slackClient, pr, "Closed", "")
+		}
+	}
+}
+
+// requestedReviewer returns the login of the reviewer requested in the raw
+// payload of a "review_requested" pull request event, or "" if it can't be
+// parsed. The vendored client doesn't decode it.
+func requestedReviewer(payload []byte) string {
+	var request struct {
+		RequestedReviewer github.User `json:"requested_reviewer"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		log.WithError(err).Warn("unable to parse review request")
+		return ""
+	}
+	return request.RequestedReviewer.GetLogin()
+}
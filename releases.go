@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// releaseDownloadsSheetName is the sheet that per-asset release download counts
+// are recorded in.
+var releaseDownloadsSheetName = "Release Downloads"
+
+// handleRelease announces a newly published release in Slack. Its download
+// counts are recorded along with every other release's by
+// recordReleaseDownloads.
+func handleRelease(slackClient *slack.Client, event *github.ReleaseEvent) {
+	if event.GetAction() != "published" || event.Release.GetDraft() {
+		return
+	}
+	release := event.Release
+	repo := event.Repo.GetName()
+	log.Infof("Release %s of %s was published", release.GetTagName(), repo)
+
+	if config.ReleaseChannel != "" {
+		postToChannel(slackClient, config.ReleaseChannel,
+			releaseAnnouncement(repo, release))
+	}
+}
+
+// releaseAnnouncement returns the Slack announcement of the given release.
+func releaseAnnouncement(repo string, release *github.RepositoryRelease) string {
+	name := release.GetName()
+	if name == "" {
+		name = release.GetTagName()
+	}
+	kind := "Release"
+	if release.GetPrerelease() {
+		kind = "Pre-release"
+	}
+
+	lines := []string{fmt.Sprintf(":rocket: %s <%s|%s> of %s (`%s`) is out!",
+		kind, release.GetHTMLURL(), name, repo, release.GetTagName())}
+	if body := strings.TrimSpace(release.GetBody()); body != "" {
+		lines = append(lines, "", markdownToSlack(body))
+	}
+	if len(release.Assets) > 0 {
+		lines = append(lines, "", "*Downloads:*")
+		for _, asset := range release.Assets {
+			lines = append(lines, fmt.Sprintf("• <%s|%s> (%s)",
+				asset.GetBrowserDownloadURL(), asset.GetName(),
+				formatSize(asset.GetSize())))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	markdownHeading = regexp.MustCompile(`(?m)^#{1,6}\s+(.+?)\s*#*$`)
+	markdownBullet  = regexp.MustCompile(`(?m)^(\s*)[*+-]\s+`)
+	markdownBold    = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	markdownStrike  = regexp.MustCompile(`~~(.+?)~~`)
+	markdownImage   = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	markdownLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+)
+
+// markdownToSlack converts the GitHub flavored Markdown in a release body to
+// Slack's markup. Code spans and blocks are the same in both, so they're left
+// alone.
+func markdownToSlack(text string) string {
+	text = markdownHeading.ReplaceAllString(text, "*$1*")
+	text = markdownBullet.ReplaceAllString(text, "$1• ")
+	text = markdownBold.ReplaceAllString(text, "*$2*")
+	text = markdownStrike.ReplaceAllString(text, "~$1~")
+	text = markdownImage.ReplaceAllString(text, "<$2|$1>")
+	text = markdownLink.ReplaceAllString(text, "<$2|$1>")
+	return text
+}
+
+// formatSize returns the given number of bytes in human readable form.
+func formatSize(bytes int) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	size := float64(bytes) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if size < unit {
+			return fmt.Sprintf("%.1f %s", size, suffix)
+		}
+		size /= unit
+	}
+	return fmt.Sprintf("%.1f TB", size)
+}
+
+// recordReleaseDownloads records the current download count of every asset of
+// every release in the organization, once a day.
+func recordReleaseDownloads(githubClient *github.Client,
+	googleClient *sheets.Service) {
+	now := time.Now()
+	today := now.Format(dateFormat)
+	recorded := false
+	viewState(func(s *botState) {
+		recorded = s.ReleaseDownloadsDate == today
+	})
+	if recorded {
+		return
+	}
+
+	repos, err := listOrgRepos(githubClient)
+	if err != nil {
+		log.WithError(err).Warn("unable to list repos for release downloads")
+		return
+	}
+	var rows [][]interface{}
+	for _, repo := range repos {
+		releases, err := listReleases(githubClient, repo.GetName())
+		if err != nil {
+			log.WithError(err).Warnf("unable to list releases of %s",
+				repo.GetName())
+			continue
+		}
+		for _, release := range releases {
+			rows = append(rows,
+				releaseDownloadRows(now, repo.GetName(), release)...)
+		}
+	}
+	if err := appendReleaseDownloads(googleClient, rows); err != nil {
+		log.WithError(err).Warn("unable to record release downloads")
+		return
+	}
+	updateState(func(s *botState) {
+		s.ReleaseDownloadsDate = today
+	})
+}
+
+// listReleases returns all of the releases of the given repository.
+func listReleases(client *github.Client, repo string) (
+	[]*github.RepositoryRelease, error) {
+	var releases []*github.RepositoryRelease
+	opts := &github.ListOptions{}
+	for {
+		page, resp, err := client.Repositories.ListReleases(ctx(), "kelda",
+			repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, page...)
+		if resp.NextPage == 0 {
+			return releases, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// releaseDownloadRows returns the rows of the release downloads sheet for the
+// assets of the given release.
+func releaseDownloadRows(now time.Time, repo string,
+	release *github.RepositoryRelease) [][]interface{} {
+	var rows [][]interface{}
+	for _, asset := range release.Assets {
+		rows = append(rows, []interface{}{
+			now.Format(timeFormat),
+			repo,
+			release.GetTagName(),
+			asset.GetName(),
+			asset.GetDownloadCount(),
+		})
+	}
+	return rows
+}
+
+// appendReleaseDownloads adds the given rows to the release downloads sheet,
+// creating it if needed.
+func appendReleaseDownloads(googleClient *sheets.Service,
+	rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	header := []interface{}{"Date", "Repo", "Release", "Asset", "Downloads"}
+	if err := ensureSheet(googleClient, releaseDownloadsSheetName,
+		header); err != nil {
+		return err
+	}
+	return appendRows(googleClient, releaseDownloadsSheetName, rows)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestMarkdownToSlack(t *testing.T) {
+	tests := []struct {
+		name, markdown, want string
+	}{
+		{
+			name:     "headings",
+			markdown: "## What's new ##\n### Fixes",
+			want:     "*What's new*\n*Fixes*",
+		},
+		{
+			name:     "bullets",
+			markdown: "- one\n* two\n  + nested",
+			want:     "• one\n• two\n  • nested",
+		},
+		{
+			name:     "bold and strikethrough",
+			markdown: "**new** and __improved__, ~~old~~",
+			want:     "*new* and *improved*, ~old~",
+		},
+		{
+			name: "links and images",
+			markdown: "See [the docs](https://kelda.io/docs \"Docs\") " +
+				"and ![a logo](https://kelda.io/logo.png)",
+			want: "See <https://kelda.io/docs|the docs> " +
+				"and <https://kelda.io/logo.png|a logo>",
+		},
+		{
+			name:     "code is left alone",
+			markdown: "Run `kelda up`:\n```\nkelda up\n```",
+			want:     "Run `kelda up`:\n```\nkelda up\n```",
+		},
+	}
+
+	for _, test := range tests {
+		got := markdownToSlack(test.markdown)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
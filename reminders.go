@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// issueEvent is an event in the timeline of a pull request. The vendored
+// GitHub client's IssueEvent doesn't include the requested reviewer, so we
+// decode the events ourselves.
+type issueEvent struct {
+	Event             string      `json:"event"`
+	CreatedAt         time.Time   `json:"created_at"`
+	RequestedReviewer github.User `json:"requested_reviewer"`
+}
+
+// prKey returns a string that uniquely identifies the given pull request
+// within the organization, for use as a key in the bot's state.
+func prKey(pr *github.PullRequest) string {
+	return itemKey(*pr.Base.Repo.Name, *pr.Number)
+}
+
+// parsePRKey returns the repository and number of the pull request identified
+// by the given prKey.
+func parsePRKey(key string) (repo string, number int, err error) {
+	return parseItemKey(key)
+}
+
+// itemKey returns a string that uniquely identifies the issue or pull request
+// with the given number within the organization, for use as a key in the bot's
+// state.
+func itemKey(repo string, number int) string {
+	return fmt.Sprintf("%s#%d", repo, number)
+}
+
+// parseItemKey returns the repository and number of the issue or pull request
+// identified by the given itemKey.
+func parseItemKey(key string) (repo string, number int, err error) {
+	parts := strings.SplitN(key, "#", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", 0, fmt.Errorf("%q isn't of the form repo#123", key)
+	}
+	number, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("%q isn't of the form repo#123", key)
+	}
+	return parts[0], number, nil
+}
+
+// getReviewRequestTimes returns when a review was most recently requested from
+// each of the PR's reviewers.
+func getReviewRequestTimes(client *github.Client, pr *github.PullRequest) (
+	map[string]time.Time, error) {
+
+	times := map[string]time.Time{}
+	url := fmt.Sprintf("/repos/kelda/%s/issues/%d/events?per_page=100",
+		*pr.Base.Repo.Name, *pr.Number)
+	for url != "" {
+		req, err := client.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var events []issueEvent
+		resp, err := client.Do(ctx(), req, &events)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			if e.Event == "review_requested" {
+				times[e.RequestedReviewer.GetLogin()] = e.CreatedAt
+			}
+		}
+
+		url = ""
+		if resp.NextPage != 0 {
+			url = fmt.Sprintf("/repos/kelda/%s/issues/%d/events?per_page=100&page=%d",
+				*pr.Base.Repo.Name, *pr.Number, resp.NextPage)
+		}
+	}
+	return times, nil
+}
+
+var (
+	// requestTimes caches getReviewRequestTimes for the outstanding
+	// reviewers of each open pull request, keyed by prKey.
+	requestTimes     = map[string]map[string]time.Time{}
+	requestTimesLock sync.Mutex
+)
+
+// reviewRequestTimes returns when a review was requested from each of the given
+// outstanding reviewers of pr. A request time only changes when someone is
+// asked to review again, by which point they've usually dropped out of the
+// outstanding reviewers, so the PR's events are only fetched when one of its
+// reviewers' request time isn't known yet.
+func reviewRequestTimes(client *github.Client, pr *github.PullRequest,
+	reviewers []github.User) (map[string]time.Time, error) {
+	requestTimesLock.Lock()
+	defer requestTimesLock.Unlock()
+
+	cached := requestTimes[prKey(pr)]
+	times := map[string]time.Time{}
+	for _, reviewer := range reviewers {
+		login := reviewer.GetLogin()
+		since, ok := cached[login]
+		if !ok {
+			fetched, err := getReviewRequestTimes(client, pr)
+			if err != nil {
+				return nil, err
+			}
+			times = map[string]time.Time{}
+			for _, reviewer := range reviewers {
+				login := reviewer.GetLogin()
+				if since, ok := fetched[login]; ok {
+					times[login] = since
+				}
+			}
+			break
+		}
+		times[login] = since
+	}
+	requestTimes[prKey(pr)] = times
+	return times, nil
+}
+
+// forgetRequestTimes drops the cached review request times of pull requests
+// that are no longer open. open holds the prKey of every open pull request.
+func forgetRequestTimes(open map[string]bool) {
+	requestTimesLock.Lock()
+	defer requestTimesLock.Unlock()
+	for key := range requestTimes {
+		if !open[key] {
+			delete(requestTimes, key)
+		}
+	}
+}
+
+// remindReviewers sends a Slack reminder to each of the given reviewers of pr
+// who has had the review for at least config.ReminderHours of their own
+// working hours without acting on it. requested holds when each review was
+// requested, as returned by getReviewRequestTimes. Reminders are repeated every
+// config.ReminderHours working hours, and are only sent during the reviewer's
+// working hours.
+func remindReviewers(slackClient *slack.Client, pr *github.PullRequest,
+	reviewers []github.User, requested map[string]time.Time) {
+	if config.ReminderHours == 0 {
+		return
+	}
+
+	now := time.Now()
+	threshold := time.Duration(config.ReminderHours) * time.Hour
+	for _, reviewer := range reviewers {
+		login := reviewer.GetLogin()
+		since, ok := requested[login]
+		if !ok {
+			continue
+		}
+
+		waited := since
+		started := false
+		viewState(func(s *botState) {
+			if last := s.Reminders[prKey(pr)][login]; last.After(waited) {
+				waited = last
+			}
+			started = s.ReviewsStarted[prKey(pr)][login].After(since)
+		})
+		if started {
+			// They said they're working on it.
+			continue
+		}
+
+		loc := userLocation(slackClient, login)
+		if !inWorkingHours(now, loc) ||
+			businessHoursBetween(waited, now, loc) < threshold {
+			continue
+		}
+
+		hours := businessHoursBetween(since, now, loc).Hours()
+		notifyUser(slackClient, login, fmt.Sprintf(
+			"Reminder: <%s|%s: %s> has been waiting %.0f working hours "+
+				"for your review.",
+			pr.GetHTMLURL(), prKey(pr), pr.GetTitle(), hours))
+		updateState(func(s *botState) {
+			if s.Reminders == nil {
+				s.Reminders = map[string]map[string]time.Time{}
+			}
+			if s.Reminders[prKey(pr)] == nil {
+				s.Reminders[prKey(pr)] = map[string]time.Time{}
+			}
+			s.Reminders[prKey(pr)][login] = now
+		})
+	}
+}
@@ -2,40 +2,71 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
+	"sync"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
 )
 
 type review struct {
-	State string
-	User  github.User
+	State       string
+	User        github.User
+	SubmittedAt time.Time `json:"submitted_at"`
 }
 
-func runReview(client *github.Client) {
-	repos, _, err := client.Repositories.ListByOrg(ctx(), "kelda", nil)
+func runReview(client *github.Client, slackClient *slack.Client) {
+	repos, err := listOrgRepos(client)
 	if err != nil {
-		log.Println("Failed to list repos: ", err)
+		log.WithError(err).Warn("unable to list repos")
 		return
 	}
 
+	openPRs := map[string]bool{}
+	var summaries []prSummary
 	for _, repo := range repos {
-		prs, _, err := client.PullRequests.List(ctx(), "kelda", *repo.Name, nil)
+		prs, err := listOpenPRs(client, *repo.Name)
 		if err != nil {
-			log.Println("Failed to list pull requests: ", err)
+			log.WithError(err).Warnf("unable to list pull requests in %s",
+				*repo.Name)
 			return
 		}
 
 		for _, pr := range prs {
-			processPullRequest(client, pr)
+			openPRs[prKey(pr)] = true
+			summaries = append(summaries,
+				processPullRequest(client, slackClient, pr))
 		}
 	}
+	forgetClosedPRs(openPRs)
+	forgetRequestTimes(openPRs)
+	runMissedBackports(client, openPRs)
+	advanceAllMergeQueues(client, slackClient)
+	setReviewSnapshot(summaries)
 }
 
-var memberIndex = rand.Intn(100)
-var committerIndex = rand.Intn(100)
+// listOpenPRs returns all of the open pull requests in the given repository.
+func listOpenPRs(client *github.Client, repo string) (
+	[]*github.PullRequest, error) {
+	var prs []*github.PullRequest
+	opts := &github.PullRequestListOptions{}
+	for {
+		page, resp, err := client.PullRequests.List(ctx(), "kelda", repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		prs = append(prs, page...)
+		if resp.NextPage == 0 {
+			return prs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+var memberRotation = newRotation()
+var committerRotation = newRotation()
 
 func userInList(user *string, listOfUsers []string) bool {
 	for _, userInList := range listOfUsers {
@@ -46,21 +77,55 @@ func userInList(user *string, listOfUsers []string) bool {
 	return false
 }
 
-func processPullRequest(client *github.Client, pr *github.PullRequest) {
-	log.Printf("Processing PR %d\n", *pr.Number)
+func processPullRequest(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest) prSummary {
+	log.Debugf("processing PR %d", *pr.Number)
 	members, committers := getTeamMembers(client)
+	summary := prSummary{pr: pr, waiting: map[string]time.Time{}}
 
 	// Return if there are any reviewers who have been assigned but who
 	// haven't done anything yet.
 	reviewers, err := getRequestedReviewers(client, pr)
 	if err != nil {
-		log.Println("Failed to list requested reviewers: ", err)
-		return
+		log.WithError(err).Warnf("unable to list requested reviewers of "+
+			"PR %d", *pr.Number)
+		return summary
 	}
 	if len(reviewers) > 0 {
-		log.Printf("PR %d has %d outstanding reviewers\n",
+		log.Debugf("PR %d has %d outstanding reviewers",
 			*pr.Number, len(reviewers))
-		return
+		requested, err := reviewRequestTimes(client, pr, reviewers)
+		if err != nil {
+			log.WithError(err).Warnf("unable to get review request "+
+				"times for PR %d", *pr.Number)
+			requested = map[string]time.Time{}
+		}
+
+		summary.stage = stageFirstReview
+		for _, reviewer := range reviewers {
+			login := reviewer.GetLogin()
+			if userInList(&login, committers) {
+				summary.stage = stageCommitterReview
+			}
+			if since, ok := requested[login]; ok {
+				summary.waiting[login] = since
+			} else {
+				summary.waiting[login] = pr.GetCreatedAt()
+			}
+		}
+		if hasMergeConflict(pr) {
+			// Committers are only asked to review to merge the
+			// PR, which they can't do until it's rebased.
+			var remind []github.User
+			for _, reviewer := range reviewers {
+				if !userInList(reviewer.Login, committers) {
+					remind = append(remind, reviewer)
+				}
+			}
+			reviewers = remind
+		}
+		remindReviewers(slackClient, pr, reviewers, requested)
+		return summary
 	}
 
 	// Determine what reviews have already occurred. This list will include
@@ -68,14 +133,37 @@ func processPullRequest(client *github.Client, pr *github.PullRequest) {
 	// reviews that approved the PR.
 	reviews, err := getReviews(client, pr)
 	if err != nil {
-		log.Println("Failed to list reviews: ", err)
-		return
+		log.WithError(err).Warnf("unable to list reviews of PR %d",
+			*pr.Number)
+		return summary
 	}
 
 	if len(reviews) == 0 {
-		// The pull request has had no reviews, so assign a reviewer.
-		assignReviewer(client, pr, members, &memberIndex)
-		return
+		// The pull request has had no reviews, so assign a reviewer, unless
+		// its repo holds reviews until the description is complete.
+		summary.stage = stageFirstReview
+		rules := config.Repos[*pr.Base.Repo.Name].Description
+		if rules != nil && rules.HoldReview &&
+			!descriptionPasses(client, pr) {
+			log.Infof("holding review of PR %d until its description "+
+				"is complete", *pr.Number)
+			return summary
+		}
+		reviewer := assignReviewer(client, slackClient, pr, members,
+			memberRotation, getPRAuthors(client, pr))
+		if reviewer == "" {
+			return summary
+		}
+		summary.waiting[reviewer] = time.Now()
+		if getPRThread(pr) == nil {
+			startPRThread(slackClient, pr, reviewer)
+		} else {
+			postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+				":eyes: %s was asked to review.", reviewer))
+			setPRThreadStatus(slackClient, pr, fmt.Sprintf(
+				"Waiting for a review from %s", reviewer), "")
+		}
+		return summary
 	}
 
 	// Parse the reviews to determine whether a second person needs to be assigned
@@ -100,45 +188,252 @@ func processPullRequest(client *github.Client, pr *github.PullRequest) {
 	}
 
 	prByCommitter := userInList(pr.User.Login, committers)
-	if nonCommitterApproved && !committerReviewedAfterApproval &&
-		!prByCommitter {
+	needsCommitter := nonCommitterApproved && !committerReviewedAfterApproval &&
+		!prByCommitter
+	switch {
+	case committerApproved(committerVerdicts(reviews, committers),
+		*pr.User.Login):
+		summary.stage = stageApproved
+	case nonCommitterApproved:
+		summary.stage = stageCommitterReview
+	default:
+		summary.stage = stageInReview
+	}
+	if needsCommitter && hasMergeConflict(pr) {
+		// There's no point asking a committer to merge a PR that can't
+		// be merged; the author has been asked to rebase it.
+		log.Infof("PR %d has merge conflicts, so not assigning a committer",
+			*pr.Number)
+	} else if needsCommitter {
 		// A committer hasn't yet been involved in this pull request, so assign
 		// one.
-		assignReviewer(client, pr, committers, &committerIndex)
+		reviewer := assignReviewer(client, slackClient, pr, committers,
+			committerRotation, getPRAuthors(client, pr))
+		if reviewer != "" {
+			summary.waiting[reviewer] = time.Now()
+			postToPRThread(slackClient, prKey(pr), fmt.Sprintf(
+				":eyes: Committer %s was asked to review.", reviewer))
+			setPRThreadStatus(slackClient, pr, fmt.Sprintf(
+				"Waiting for a committer review from %s", reviewer), "")
+		}
 	}
 	// Either there's an in-process review (e.g., a non-committer has done
 	// a review but not approved it yet), in which case we don't need to
 	// assign anyone else yet, or a committer has seen the PR, so no one
 	// else needs to review it.
+
+	if summary.stage != stageApproved {
+		return summary
+	}
+	if config.Repos[*pr.Base.Repo.Name].MergeQueue {
+		enqueuePR(client, slackClient, pr)
+	} else {
+		handleApprovedPR(client, slackClient, pr)
+	}
+	return summary
 }
 
-func assignReviewer(client *github.Client, pr *github.PullRequest,
-	reviewerOptions []string, index *int) {
-	reviewer := ""
+// committerVerdicts returns the most recent review state of each committer who
+// has reviewed a PR, keyed by login. Only approvals and requests for changes
+// count, so a committer's comments don't undo their approval.
+func committerVerdicts(reviews []review, committers []string) map[string]string {
+	verdicts := map[string]string{}
+	for _, review := range reviews {
+		if userInList(review.User.Login, committers) &&
+			review.State != "COMMENTED" {
+			verdicts[*review.User.Login] = review.State
+		}
+	}
+	return verdicts
+}
 
-	// Choose a reviewer from the list, who isn't the author of the PR.
-	for i := 0; i < len(reviewerOptions); i++ {
-		*index++
-		possibleReviewer := reviewerOptions[*index%len(reviewerOptions)]
-		if possibleReviewer != *pr.User.Login {
-			reviewer = possibleReviewer
-			break
+// isCommitterApproved fetches the reviews of the given pull request, and
+// returns whether it's approved by a committer, as committerApproved decides.
+func isCommitterApproved(client *github.Client, pr *github.PullRequest) (
+	bool, error) {
+	_, committers := getTeamMembers(client)
+	reviews, err := getReviews(client, pr)
+	if err != nil {
+		return false, err
+	}
+	return committerApproved(committerVerdicts(reviews, committers),
+		*pr.User.Login), nil
+}
+
+// committerApproved returns whether a committer other than the PR's author has
+// approved it, and no committer has requested changes. verdicts holds the most
+// recent review state of each committer who has reviewed the PR.
+func committerApproved(verdicts map[string]string, author string) bool {
+	approved := false
+	for committer, state := range verdicts {
+		switch {
+		case state == "CHANGES_REQUESTED":
+			return false
+		case state == "APPROVED" && committer != author:
+			approved = true
 		}
 	}
+	return approved
+}
+
+// assignReviewer requests a review of the PR from the next available person in
+// reviewerOptions who isn't one of the PR's authors and didn't pass on it,
+// preferring people who are currently active on Slack. Pull requests from
+// first-time contributors go to mentors when one is available. If no one is
+// available, it falls back to the next person who isn't an author. It returns
+// the person who was asked to review, or an empty string if no one was.
+func assignReviewer(client *github.Client, slackClient *slack.Client,
+	pr *github.PullRequest, reviewerOptions []string, r *rotation,
+	authors []string) string {
+	wantMentor := isFirstTimePR(pr)
+	skip := func(login string) bool {
+		return userInList(&login, authors) || passedOn(pr, login)
+	}
+	preferred := func(login string) bool {
+		return !wantMentor || config.Users[login].Mentor
+	}
+	available := func(login string) bool {
+		return isAvailable(client, login)
+	}
+	reviewer, fellBack := pickFromRotation(slackClient, reviewerOptions, r,
+		skip, available, preferred)
+	if fellBack {
+		log.Warnf("all potential reviewers for PR %d are away or at "+
+			"their review limit; falling back to %s",
+			*pr.Number, reviewer)
+	}
 	if reviewer == "" {
-		log.Printf("No potential reviewers for PR %d\n", *pr.Number)
-		return
+		log.Warnf("no potential reviewers for PR %d", *pr.Number)
+		return ""
 	}
 
-	log.Printf("Assigning pull request %d review to %s\n", *pr.Number, reviewer)
+	log.Infof("assigning pull request %d review to %s", *pr.Number,
+		reviewer)
 	post := map[string][]string{
 		"reviewers": []string{reviewer},
 	}
 	err := prRequest(client, pr, "POST", "requested_reviewers", &post, nil)
 	if err != nil {
-		log.Printf("Failed to assign %s to PR %d: %s\n",
-			reviewer, *pr.Number, err)
+		log.WithError(err).Warnf("unable to assign %s to PR %d",
+			reviewer, *pr.Number)
+		return ""
+	}
+	noteReviewAssigned(reviewer)
+
+	notifyDuringWorkingHours(slackClient, reviewer, fmt.Sprintf(
+		"You've been asked to review <%s|%s#%d: %s> by %s.",
+		pr.GetHTMLURL(), *pr.Base.Repo.Name, *pr.Number, pr.GetTitle(),
+		*pr.User.Login), reviewActions(pr))
+	return reviewer
+}
+
+// rotation is the position of a rotation of people who take turns at a job.
+type rotation struct {
+	lock  sync.Mutex
+	index int
+
+	// early holds the people who were picked before their turn, because
+	// the people ahead of them weren't active on Slack. They're passed
+	// over once the rotation reaches them, so that no one loses a turn.
+	early map[string]bool
+}
+
+// newRotation returns a rotation starting at a random position.
+func newRotation() *rotation {
+	return &rotation{index: rand.Intn(100), early: map[string]bool{}}
+}
+
+// rotationCandidate is a person in a rotation, along with their position.
+type rotationCandidate struct {
+	login    string
+	position int
+}
+
+// pickFromRotation returns the person in options whose turn it is in r, not
+// counting people for whom skip returns true. Only people for whom available
+// returns true are considered, and those for whom preferred returns true win
+// over the rest. If the person whose turn it is isn't active on Slack,
+// the next one who is takes their place, and the rotation stays where it is
+// so that the person who was passed over keeps their turn. If no one is
+// available, it falls back to the next person who isn't skipped, and reports
+// that it did so. It returns an empty string if everyone is skipped.
+func pickFromRotation(slackClient *slack.Client, options []string, r *rotation,
+	skip, available, preferred func(string) bool) (string, bool) {
+	r.lock.Lock()
+	start := r.index
+	early := map[string]bool{}
+	for login := range r.early {
+		early[login] = true
+	}
+	r.lock.Unlock()
+
+	var free, preferredFree []rotationCandidate
+	fallback := rotationCandidate{}
+	for i := 1; i <= len(options); i++ {
+		c := rotationCandidate{options[(start+i)%len(options)], start + i}
+		if skip(c.login) {
+			continue
+		}
+		if fallback.login == "" {
+			fallback = c
+		}
+		if !available(c.login) {
+			continue
+		}
+		free = append(free, c)
+		if preferred(c.login) {
+			preferredFree = append(preferredFree, c)
+		}
+	}
+	if len(free) == 0 {
+		if fallback.login == "" {
+			return "", false
+		}
+		r.advance(options, start, fallback)
+		return fallback.login, true
+	}
+
+	// Whose turn it is depends on who's preferred, and on who has already
+	// been picked early.
+	eligible := free
+	if len(preferredFree) > 0 {
+		eligible = preferredFree
+	}
+	var onTime []rotationCandidate
+	for _, c := range eligible {
+		if !early[c.login] {
+			onTime = append(onTime, c)
+		}
+	}
+	if len(onTime) > 0 {
+		eligible = onTime
+	}
+
+	turn := eligible[0]
+	for _, c := range eligible {
+		if isActiveOnSlack(slackClient, c.login) {
+			if c.login != turn.login {
+				r.lock.Lock()
+				r.early[c.login] = true
+				r.lock.Unlock()
+				return c.login, false
+			}
+			break
+		}
 	}
+	r.advance(options, start, turn)
+	return turn.login, false
+}
+
+// advance moves the rotation r, which was at start, to the given person. The
+// people it moves past who were picked early have now used up their turn.
+func (r *rotation) advance(options []string, start int, to rotationCandidate) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i := start + 1; i <= to.position; i++ {
+		delete(r.early, options[i%len(options)])
+	}
+	r.index = to.position
 }
 
 // getRequestedReviewers returns people from whom a review has been requested,
@@ -171,10 +466,14 @@ func prRequest(client *github.Client, pr *github.PullRequest, method,
 	return err
 }
 
-// cachedMembers and cachedCommiters contain a cached copy of all of the members of the
-// Kelda team (including the committers) and of all of the Kelda committers,
-// respectively.
-var cachedMembers, cachedCommitters []string
+// cachedMembers and cachedCommitters contain a cached copy of all of the
+// members of the Kelda team (including the committers) and of all of the Kelda
+// committers, respectively. They're replaced rather than modified, under
+// cachedMembersLock, since webhooks and the review ticker use them at once.
+var (
+	cachedMembers, cachedCommitters []string
+	cachedMembersLock               sync.Mutex
+)
 var memberRateLimit = time.Tick(time.Hour)
 
 // getTeamMembers returns two lists: the first list is of all of the members of
@@ -184,15 +483,30 @@ func getTeamMembers(client *github.Client) (members, committers []string) {
 	select {
 	case <-memberRateLimit:
 	default:
-		if cachedMembers != nil && cachedCommitters != nil {
-			return cachedMembers, cachedCommitters
+		members, committers = cachedTeamMembers()
+		if members != nil && committers != nil {
+			return members, committers
 		}
 	}
+	return refreshTeamMembers(client)
+}
 
+// cachedTeamMembers returns the cached copies of the team lists, which are nil
+// if they haven't been fetched yet.
+func cachedTeamMembers() (members, committers []string) {
+	cachedMembersLock.Lock()
+	defer cachedMembersLock.Unlock()
+	return cachedMembers, cachedCommitters
+}
+
+// refreshTeamMembers fetches the members of the Kelda team and the committers
+// from GitHub, updates the cached copies, and returns them. If fetching fails,
+// the previously cached lists are returned.
+func refreshTeamMembers(client *github.Client) (members, committers []string) {
 	teams, _, err := client.Organizations.ListTeams(ctx(), "kelda", nil)
 	if err != nil {
-		log.Println("Failed to list teams: ", err)
-		return cachedMembers, cachedCommitters
+		log.WithError(err).Warn("unable to list teams")
+		return cachedTeamMembers()
 	}
 
 	var memberID, committerID int
@@ -207,27 +521,28 @@ func getTeamMembers(client *github.Client) (members, committers []string) {
 
 	newMembers, _, err := client.Organizations.ListTeamMembers(ctx(), memberID, nil)
 	if err != nil {
-		log.Println("Failed to list team members: ", err)
-		return cachedMembers, cachedCommitters
+		log.WithError(err).Warn("unable to list team members")
+		return cachedTeamMembers()
 	}
 
 	newCommitters, _, err := client.Organizations.ListTeamMembers(ctx(), committerID, nil)
 	if err != nil {
-		log.Println("Failed to list committers: ", err)
-		return cachedMembers, cachedCommitters
+		log.WithError(err).Warn("unable to list committers")
+		return cachedTeamMembers()
 	}
 
-	cachedCommitters = []string{}
+	committers = []string{}
 	for _, c := range newCommitters {
-		committer := *c.Login
-		cachedCommitters = append(cachedCommitters, committer)
+		committers = append(committers, *c.Login)
 	}
 
-	cachedMembers = []string{}
+	members = []string{}
 	for _, m := range newMembers {
-		member := *m.Login
-		cachedMembers = append(cachedMembers, member)
+		members = append(members, *m.Login)
 	}
 
-	return cachedMembers, cachedCommitters
+	cachedMembersLock.Lock()
+	cachedMembers, cachedCommitters = members, committers
+	cachedMembersLock.Unlock()
+	return members, committers
 }
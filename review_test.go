@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// rotationTest is a call to pickFromRotation with ann, bob, and cat in the
+// rotation.
+type rotationTest struct {
+	name      string
+	active    map[string]bool
+	away      []string
+	skip      map[string]bool
+	preferred map[string]bool
+	start     int
+
+	want         string
+	wantIndex    int
+	wantFellBack bool
+}
+
+// useTestRotation configures ann, bob, and cat with Slack IDs and test.away as
+// their away periods, and returns a fake Slack on which the people in
+// test.active are active, along with a function that restores the real
+// configuration.
+func useTestRotation(t *testing.T, test rotationTest) (*slack.Client, func()) {
+	restoreState := useTestState(t)
+	config = botConfig{Users: map[string]userConfig{
+		"ann": {Slack: "ann"},
+		"bob": {Slack: "bob"},
+		"cat": {Slack: "cat"},
+	}}
+	today := date{time.Now().Truncate(24 * time.Hour)}
+	state.Away = map[string][]awayPeriod{}
+	for _, login := range test.away {
+		state.Away[login] = []awayPeriod{{Start: today, End: today}}
+	}
+
+	slackClient, closeSlack := newFakeSlack(
+		func(method string, args url.Values) string {
+			presence := "away"
+			if test.active[args.Get("user")] {
+				presence = "active"
+			}
+			return fmt.Sprintf(`{"ok": true, "presence": %q}`, presence)
+		})
+	return slackClient, func() {
+		closeSlack()
+		config = botConfig{}
+		restoreState()
+	}
+}
+
+var everyone = map[string]bool{"ann": true, "bob": true, "cat": true}
+
+func notAway(login string) bool {
+	return !isAway(login, time.Now())
+}
+
+var rotationTests = []rotationTest{
+	{
+		name:      "next in turn",
+		active:    everyone,
+		want:      "bob",
+		wantIndex: 1,
+	},
+	{
+		name:      "wraps around",
+		active:    everyone,
+		start:     2,
+		want:      "ann",
+		wantIndex: 3,
+	},
+	{
+		name:      "nobody active",
+		want:      "bob",
+		wantIndex: 1,
+	},
+	{
+		name:      "skipped",
+		active:    everyone,
+		skip:      map[string]bool{"bob": true},
+		want:      "cat",
+		wantIndex: 2,
+	},
+	{
+		name:      "away",
+		active:    everyone,
+		away:      []string{"bob"},
+		want:      "cat",
+		wantIndex: 2,
+	},
+	{
+		name:      "preferred",
+		active:    everyone,
+		preferred: map[string]bool{"cat": true},
+		want:      "cat",
+		wantIndex: 2,
+	},
+	{
+		name:      "preferred but inactive",
+		active:    map[string]bool{"bob": true},
+		preferred: map[string]bool{"cat": true},
+		want:      "cat",
+		wantIndex: 2,
+	},
+	{
+		name:         "everyone away",
+		active:       everyone,
+		away:         []string{"ann", "bob", "cat"},
+		want:         "bob",
+		wantIndex:    1,
+		wantFellBack: true,
+	},
+	{
+		name:      "everyone skipped",
+		active:    everyone,
+		skip:      everyone,
+		want:      "",
+		wantIndex: 0,
+	},
+}
+
+func TestPickFromRotation(t *testing.T) {
+	// Someone who's picked before their turn doesn't move the rotation.
+	tests := append(rotationTests, rotationTest{
+		name:      "passes over inactive",
+		active:    map[string]bool{"cat": true},
+		want:      "cat",
+		wantIndex: 0,
+	})
+
+	for _, test := range tests {
+		slackClient, restore := useTestRotation(t, test)
+		preferred := func(login string) bool {
+			return test.preferred == nil || test.preferred[login]
+		}
+		skip := func(login string) bool { return test.skip[login] }
+		r := &rotation{index: test.start, early: map[string]bool{}}
+		got, fellBack := pickFromRotation(slackClient,
+			[]string{"ann", "bob", "cat"}, r, skip, notAway, preferred)
+		restore()
+
+		if got != test.want || fellBack != test.wantFellBack {
+			t.Errorf("%s: got %q (fell back: %v), want %q (fell back: %v)",
+				test.name, got, fellBack, test.want, test.wantFellBack)
+		}
+		if r.index != test.wantIndex {
+			t.Errorf("%s: got index %d, want %d", test.name, r.index,
+				test.wantIndex)
+		}
+	}
+}
+
+func TestRotationKeepsTurn(t *testing.T) {
+	options := []string{"ann", "bob", "cat"}
+	r := &rotation{early: map[string]bool{}}
+	none := func(string) bool { return false }
+	all := func(string) bool { return true }
+
+	// It's bob's turn, but only cat is active, so cat goes early and bob
+	// keeps their turn.
+	steps := []struct {
+		active map[string]bool
+		want   string
+	}{
+		{map[string]bool{"cat": true}, "cat"},
+		{everyone, "bob"},
+		{everyone, "ann"},
+		{everyone, "bob"},
+	}
+	for i, step := range steps {
+		slackClient, restore := useTestRotation(t,
+			rotationTest{active: step.active})
+		got, _ := pickFromRotation(slackClient, options, r, none, all,
+			all)
+		restore()
+		if got != step.want {
+			t.Errorf("pick %d: got %q, want %q", i+1, got, step.want)
+		}
+	}
+}
+
+func TestRotationAdvance(t *testing.T) {
+	options := []string{"ann", "bob", "cat"}
+	r := &rotation{index: 0, early: map[string]bool{"bob": true, "ann": true}}
+	r.advance(options, 0, rotationCandidate{"bob", 1})
+	if r.index != 1 {
+		t.Errorf("got index %d, want 1", r.index)
+	}
+	if r.early["bob"] || !r.early["ann"] {
+		t.Errorf("got early %v, want only ann", r.early)
+	}
+
+	// Advancing past the end of the options wraps around.
+	r.advance(options, 1, rotationCandidate{"ann", 3})
+	if r.index != 3 || len(r.early) != 0 {
+		t.Errorf("got index %d and early %v, want 3 and none", r.index,
+			r.early)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// slackSigningSecret is used to verify that requests to the Slack endpoints
+// actually came from Slack.
+var slackSigningSecret string
+
+// maxSlackRequestAge is how old a signed Slack request can be before it's
+// rejected, to protect against replay attacks.
+const maxSlackRequestAge = 5 * time.Minute
+
+// readSlackRequest returns the body of the given request after verifying that
+// it was signed by Slack.
+func readSlackRequest(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if slackSigningSecret == "" {
+		return nil, errors.New("no Slack signing secret is configured")
+	}
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad request timestamp: %s", err)
+	}
+	age := time.Since(time.Unix(sent, 0))
+	if math.Abs(float64(age)) > float64(maxSlackRequestAge) {
+		return nil, errors.New("request timestamp is too old")
+	}
+
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return nil, errors.New("bad request signature")
+	}
+	return body, nil
+}
+
+// respondToSlack sends response, which is encoded as JSON, to the response URL
+// of a Slack command or action. It's used to reply after the request itself
+// has been answered.
+func respondToSlack(responseURL string, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.WithError(err).Warn("unable to encode Slack response")
+		return
+	}
+	resp, err := http.Post(responseURL, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		log.WithError(err).Warn("unable to send Slack response")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Warnf("Slack rejected a response: %s", resp.Status)
+	}
+}
+
+// slackCommandHandler returns an HTTP handler for the bot's Slack slash
+// command. The text of the slash command is run as a bot command on behalf of
+// the GitHub user configured for the Slack user who issued it.
+func slackCommandHandler(githubClient *github.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := readSlackRequest(r)
+		if err != nil {
+			log.WithError(err).Warn("rejected Slack command")
+			http.Error(w, "invalid request", http.StatusUnauthorized)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+
+		login, ok := loginForSlackUser(form.Get("user_id"))
+		if !ok {
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "I don't know your GitHub login. Ask an "+
+				"admin to add your Slack ID to the bot's config file.")
+			return
+		}
+
+		// Commands like changelog can take longer than the three
+		// seconds Slack waits for an answer, so the reply is sent
+		// through the response URL instead.
+		w.WriteHeader(http.StatusOK)
+		go func() {
+			reply := runCommand(githubClient, commandRequest{
+				login: login,
+				args:  strings.Fields(form.Get("text")),
+			})
+			respondToSlack(form.Get("response_url"),
+				map[string]string{"text": reply})
+		}()
+	}
+}
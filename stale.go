@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+const (
+	// staleLabel is added to issues and pull requests that have been
+	// inactive for too long.
+	staleLabel = "stale"
+
+	// staleMarker identifies the bot's comments about stale issues and
+	// pull requests.
+	staleMarker = "<!-- kelda-bot:stale -->"
+
+	// staleGracePeriod allows for the difference between our clock and
+	// GitHub's when deciding whether an item saw activity after it was
+	// marked stale.
+	staleGracePeriod = time.Minute
+)
+
+// staleItem is an issue or pull request found by sweepStaleItems.
+type staleItem struct {
+	repo  string
+	issue github.Issue
+}
+
+func (item staleItem) key() string {
+	return itemKey(item.repo, item.issue.GetNumber())
+}
+
+func (item staleItem) kind() string {
+	if item.issue.PullRequestLinks != nil {
+		return "pull request"
+	}
+	return "issue"
+}
+
+// hasLabel returns whether the item has any of the given labels.
+func (item staleItem) hasLabel(names ...string) bool {
+	for _, label := range item.issue.Labels {
+		for _, name := range names {
+			if label.GetName() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// staleThresholdsFor returns the stale thresholds of the given repository.
+func staleThresholdsFor(repo string) staleThresholds {
+	if override := config.Repos[repo].Stale; override != nil {
+		return *override
+	}
+	return config.Stale.staleThresholds
+}
+
+// sweepStaleItems finds the open issues and pull requests in the organization
+// that have had no activity in their repository's stale threshold, excluding
+// the ones with exempt labels. They're labeled stale with a warning comment,
+// and closed if they stay inactive for the repository's close threshold. Items
+// that become active again are unmarked. If config.Stale.DryRun is set, nothing
+// is changed, and the sweep only reports what it would have done.
+func sweepStaleItems(client *github.Client, slackClient *slack.Client) {
+	repos, err := listOrgRepos(client)
+	if err != nil {
+		log.WithError(err).Warn("unable to list repos for the stale sweep")
+		return
+	}
+
+	var marked, closed []staleItem
+	var failed []string
+	for _, repo := range repos {
+		name := repo.GetName()
+		thresholds := staleThresholdsFor(name)
+		if thresholds.Days == 0 {
+			continue
+		}
+
+		m, c, err := sweepRepoStaleItems(client, name, thresholds)
+		if err != nil {
+			log.WithError(err).Warnf("unable to sweep %s for stale items",
+				name)
+			failed = append(failed, name)
+		}
+		marked = append(marked, m...)
+		closed = append(closed, c...)
+	}
+	if len(marked) == 0 && len(closed) == 0 && len(failed) == 0 {
+		return
+	}
+
+	report := staleReport(marked, closed, failed)
+	if config.Stale.ReportChannel != "" {
+		postToChannel(slackClient, config.Stale.ReportChannel, report)
+	} else {
+		log.Info(report)
+	}
+}
+
+// sweepRepoStaleItems runs the stale sweep on a single repository, and returns
+// the items that it marked stale and closed. If it fails partway through, it
+// returns what it did before failing along with the error.
+func sweepRepoStaleItems(client *github.Client, repo string,
+	thresholds staleThresholds) (marked, closed []staleItem, err error) {
+	now := time.Now()
+
+	// First deal with the items that are already stale: unmark the ones
+	// that saw activity or have since been exempted, and close the ones
+	// that stayed inactive for long enough.
+	labeled, err := listRepoItems(client, repo, &github.IssueListByRepoOptions{
+		Labels: []string{staleLabel},
+	}, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't list stale items: %s", err)
+	}
+	stillStale := map[string]bool{}
+	for _, item := range labeled {
+		since := staleSince(item)
+		active := item.hasLabel(config.Stale.ExemptLabels...)
+		if !active && item.issue.GetUpdatedAt().After(
+			since.Add(staleGracePeriod)) {
+			active, err = activeSince(client, item,
+				since.Add(staleGracePeriod))
+			if err != nil {
+				log.WithError(err).Warnf("unable to check %s for "+
+					"activity", item.key())
+				stillStale[item.key()] = true
+				continue
+			}
+		}
+		if active {
+			if config.Stale.DryRun {
+				stillStale[item.key()] = true
+			} else {
+				unmarkStale(client, item.repo, item.issue.GetNumber())
+			}
+			continue
+		}
+		stillStale[item.key()] = true
+		if thresholds.CloseDays == 0 ||
+			now.Sub(since) < days(thresholds.CloseDays) {
+			continue
+		}
+		if config.Stale.DryRun || closeStale(client, item, thresholds) {
+			closed = append(closed, item)
+		}
+	}
+	forgetStaleItems(repo, stillStale)
+
+	// Then look for newly inactive items, oldest first, stopping at the
+	// first one that's been updated too recently. Items that are so
+	// inactive that they'd have been closed are reported as such in dry
+	// runs, so that the report shows what turning closing on would do.
+	cutoff := now.Add(-days(thresholds.Days))
+	inactive, err := listRepoItems(client, repo, &github.IssueListByRepoOptions{
+		Sort:      "updated",
+		Direction: "asc",
+	}, func(issue *github.Issue) bool {
+		return !issue.GetUpdatedAt().Before(cutoff)
+	})
+	if err != nil {
+		return marked, closed, fmt.Errorf("couldn't list inactive items: %s",
+			err)
+	}
+	skip := append([]string{staleLabel}, config.Stale.ExemptLabels...)
+	for _, item := range inactive {
+		if item.hasLabel(skip...) {
+			continue
+		}
+		if config.Stale.DryRun {
+			idle := now.Sub(item.issue.GetUpdatedAt())
+			if thresholds.CloseDays != 0 &&
+				idle >= days(thresholds.Days+thresholds.CloseDays) {
+				closed = append(closed, item)
+			} else {
+				marked = append(marked, item)
+			}
+		} else if markStale(client, item, thresholds) {
+			marked = append(marked, item)
+		}
+	}
+	return marked, closed, nil
+}
+
+// listRepoItems returns the open issues and pull requests in repo that match
+// opts, stopping at the first one for which stop returns true, if stop isn't
+// nil. It lists issues rather than searching for them, since the search API's
+// rate limit is much lower, and is shared with the rest of the bot's searches.
+func listRepoItems(client *github.Client, repo string,
+	opts *github.IssueListByRepoOptions, stop func(*github.Issue) bool) (
+	[]staleItem, error) {
+	opts.State = "open"
+	opts.PerPage = 100
+	var items []staleItem
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx(), "kelda", repo,
+			opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			if stop != nil && stop(issue) {
+				return items, nil
+			}
+			items = append(items, staleItem{repo: repo, issue: *issue})
+		}
+		if resp.NextPage == 0 {
+			return items, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// activeSince returns whether anyone other than the bot has commented on, or
+// otherwise acted on, the given item since the given time. The bot's own
+// comments and edits update items too, but they don't make an item active.
+func activeSince(client *github.Client, item staleItem, since time.Time) (
+	bool, error) {
+	number := item.issue.GetNumber()
+	commentOpts := &github.IssueListCommentsOptions{Since: since}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx(), "kelda",
+			item.repo, number, commentOpts)
+		if err != nil {
+			return false, err
+		}
+		for _, comment := range comments {
+			if comment.GetUpdatedAt().After(since) &&
+				!isBot(client, comment.User.GetLogin()) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		commentOpts.Page = resp.NextPage
+	}
+
+	eventOpts := &github.ListOptions{PerPage: 100}
+	for {
+		events, resp, err := client.Issues.ListIssueEvents(ctx(), "kelda",
+			item.repo, number, eventOpts)
+		if err != nil {
+			return false, err
+		}
+		for _, event := range events {
+			if event.GetCreatedAt().After(since) &&
+				!isBot(client, event.Actor.GetLogin()) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		eventOpts.Page = resp.NextPage
+	}
+}
+
+// staleSince returns when the given item was marked stale. Items that were
+// labeled by hand, or whose record was lost, are treated as marked when they
+// were last updated.
+func staleSince(item staleItem) time.Time {
+	since := item.issue.GetUpdatedAt()
+	viewState(func(s *botState) {
+		if marked, ok := s.StaleItems[item.key()]; ok {
+			since = marked
+		}
+	})
+	return since
+}
+
+// markStale labels the given item stale and warns that it'll be closed, and
+// returns whether it succeeded.
+func markStale(client *github.Client, item staleItem,
+	thresholds staleThresholds) bool {
+	log.Infof("Marking %s stale", item.key())
+	number := item.issue.GetNumber()
+	_, _, err := client.Issues.AddLabelsToIssue(ctx(), "kelda", item.repo,
+		number, []string{staleLabel})
+	if err != nil {
+		log.WithError(err).Warnf("unable to label %s stale", item.key())
+		return false
+	}
+
+	body := fmt.Sprintf("%s\nThis %s hasn't had any activity in %d days, so "+
+		"it's been marked stale.", staleMarker, item.kind(), thresholds.Days)
+	if thresholds.CloseDays != 0 {
+		body += fmt.Sprintf(" It will be closed in %d days unless there's "+
+			"more activity.", thresholds.CloseDays)
+	}
+	body += " Comment to let us know that it's still relevant."
+	_, _, err = client.Issues.CreateComment(ctx(), "kelda", item.repo, number,
+		&github.IssueComment{Body: &body})
+	if err != nil {
+		log.WithError(err).Warnf("unable to warn that %s is stale",
+			item.key())
+	}
+
+	updateState(func(s *botState) {
+		if s.StaleItems == nil {
+			s.StaleItems = map[string]time.Time{}
+		}
+		s.StaleItems[item.key()] = time.Now()
+	})
+	return true
+}
+
+// closeStale closes the given stale item with a comment explaining why, and
+// returns whether it succeeded.
+func closeStale(client *github.Client, item staleItem,
+	thresholds staleThresholds) bool {
+	log.Infof("Closing stale %s", item.key())
+	number := item.issue.GetNumber()
+	body := fmt.Sprintf("%s\nClosing this %s, since it's had no activity in "+
+		"the %d days since it was marked stale. Feel free to reopen it.",
+		staleMarker, item.kind(), thresholds.CloseDays)
+	_, _, err := client.Issues.CreateComment(ctx(), "kelda", item.repo, number,
+		&github.IssueComment{Body: &body})
+	if err != nil {
+		log.WithError(err).Warnf("unable to comment on stale %s",
+			item.key())
+	}
+
+	_, _, err = client.Issues.Edit(ctx(), "kelda", item.repo, number,
+		&github.IssueRequest{State: github.String("closed")})
+	if err != nil {
+		log.WithError(err).Warnf("unable to close stale %s", item.key())
+		return false
+	}
+	return true
+}
+
+// unmarkStale removes the stale label from the given issue or pull request.
+func unmarkStale(client *github.Client, repo string, number int) {
+	key := itemKey(repo, number)
+	log.Infof("Unmarking %s as stale", key)
+	_, err := client.Issues.RemoveLabelForIssue(ctx(), "kelda", repo, number,
+		staleLabel)
+	if err != nil {
+		log.WithError(err).Warnf("unable to remove the stale label from %s",
+			key)
+		return
+	}
+	updateState(func(s *botState) {
+		delete(s.StaleItems, key)
+	})
+}
+
+// handleStaleActivity unmarks the given issue or pull request if it's stale,
+// since sender just acted on it. The bot's own activity doesn't count. labels
+// are the item's labels, if the event included them; otherwise, only items that
+// the bot marked stale are unmarked, and the sweep catches the rest.
+func handleStaleActivity(client *github.Client, repo string, number int,
+	labels []github.Label, sender string) {
+	if config.Stale.DryRun {
+		return
+	}
+
+	key := itemKey(repo, number)
+	stale := false
+	viewState(func(s *botState) {
+		_, stale = s.StaleItems[key]
+	})
+	for _, label := range labels {
+		if label.GetName() == staleLabel {
+			stale = true
+		}
+	}
+	if stale && !isBot(client, sender) {
+		unmarkStale(client, repo, number)
+	}
+}
+
+// forgetStaleItems removes the record of the items in repo that are no longer
+// stale. stale holds the key of every item in repo that still is.
+func forgetStaleItems(repo string, stale map[string]bool) {
+	updateState(func(s *botState) {
+		for key := range s.StaleItems {
+			if strings.HasPrefix(key, repo+"#") && !stale[key] {
+				delete(s.StaleItems, key)
+			}
+		}
+	})
+}
+
+// staleReport returns the Slack report of a stale sweep. failed holds the
+// repositories that couldn't be swept.
+func staleReport(marked, closed []staleItem, failed []string) string {
+	markVerb, closeVerb := "were marked stale", "were closed"
+	if config.Stale.DryRun {
+		markVerb, closeVerb = "would be marked stale", "would be closed"
+	}
+
+	var lines []string
+	for _, group := range []struct {
+		verb  string
+		items []staleItem
+	}{{markVerb, marked}, {closeVerb, closed}} {
+		if len(group.items) == 0 {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, fmt.Sprintf("These issues and pull requests %s:",
+			group.verb))
+		for _, item := range group.items {
+			lines = append(lines, fmt.Sprintf("• <%s|%s: %s> (last updated %s)",
+				item.issue.GetHTMLURL(), item.key(), item.issue.GetTitle(),
+				item.issue.GetUpdatedAt().Format(dateFormat)))
+		}
+	}
+	if len(failed) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, fmt.Sprintf("These repositories couldn't be "+
+			"swept, so they'll be retried tomorrow: %s",
+			strings.Join(failed, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// days returns the duration of n days.
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleThresholdsFor(t *testing.T) {
+	defer func() { config = botConfig{} }()
+	config = botConfig{
+		Stale: staleConfig{
+			staleThresholds: staleThresholds{Days: 60, CloseDays: 14},
+		},
+		Repos: map[string]repoConfig{
+			"bot":   {Stale: &staleThresholds{Days: 30}},
+			"kelda": {AutoMerge: true},
+			"docs":  {Stale: &staleThresholds{}},
+		},
+	}
+	tests := []struct {
+		repo string
+		want staleThresholds
+	}{
+		{"bot", staleThresholds{Days: 30}},
+		{"kelda", staleThresholds{Days: 60, CloseDays: 14}},
+		{"other", staleThresholds{Days: 60, CloseDays: 14}},
+		{"docs", staleThresholds{}},
+	}
+
+	for _, test := range tests {
+		if got := staleThresholdsFor(test.repo); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.repo, got, test.want)
+		}
+	}
+}
+
+func TestSweepRepoStaleItems(t *testing.T) {
+	defer useTestState(t)()
+	defer func() {
+		config = botConfig{}
+		cachedBotLogin = ""
+	}()
+	config.Stale.ExemptLabels = []string{"pinned"}
+	config.Stale.DryRun = true
+	thresholds := staleThresholds{Days: 30, CloseDays: 7}
+
+	now := time.Now()
+	ago := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	issue := func(number, updatedDaysAgo int, labels ...string) string {
+		var names []string
+		for _, label := range labels {
+			names = append(names, fmt.Sprintf(`{"name": %q}`, label))
+		}
+		return fmt.Sprintf(`{"number": %d, "updated_at": %q, "labels": [%s]}`,
+			number, ago(updatedDaysAgo).Format(time.RFC3339),
+			strings.Join(names, ", "))
+	}
+	comment := func(login string, daysAgo int) string {
+		return fmt.Sprintf(`[{"user": {"login": %q}, "updated_at": %q}]`,
+			login, ago(daysAgo).Format(time.RFC3339))
+	}
+
+	state.StaleItems = map[string]time.Time{
+		"bot#1": ago(10),
+		"bot#2": ago(3),
+		"bot#3": ago(10),
+		"bot#4": ago(10),
+		"bot#5": ago(10),
+	}
+	labeled := "[" + strings.Join([]string{
+		// Inactive since it was marked long enough ago to close.
+		issue(1, 10, "stale"),
+		// Not stale for long enough to close.
+		issue(2, 3, "stale"),
+		// Exempted since it was marked.
+		issue(3, 10, "stale", "pinned"),
+		// Someone commented since it was marked.
+		issue(4, 1, "stale"),
+		// Only the bot commented since it was marked.
+		issue(5, 1, "stale"),
+		// Labeled by hand long ago.
+		issue(10, 45, "stale"),
+	}, ", ") + "]"
+	byUpdated := "[" + strings.Join([]string{
+		// Inactive for long enough to close right away.
+		issue(8, 50),
+		issue(10, 45, "stale"),
+		issue(6, 33),
+		issue(7, 33, "pinned"),
+		// Too recently updated to be stale.
+		issue(9, 5),
+		issue(4, 1, "stale"),
+	}, ", ") + "]"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/kelda/bot/issues",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("labels") == staleLabel {
+				fmt.Fprint(w, labeled)
+			} else {
+				fmt.Fprint(w, byUpdated)
+			}
+		})
+	serveJSON(mux, "/user", `{"login": "kelda-bot"}`)
+	serveJSON(mux, "/repos/kelda/bot/issues/4/comments", comment("ann", 1))
+	serveJSON(mux, "/repos/kelda/bot/issues/5/comments",
+		comment("kelda-bot", 1))
+	serveJSON(mux, "/repos/kelda/bot/issues/5/events", `[]`)
+	client, closeGitHub := newFakeGitHub(mux)
+	defer closeGitHub()
+
+	marked, closed, err := sweepRepoStaleItems(client, "bot", thresholds)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	numbers := func(items []staleItem) []int {
+		var result []int
+		for _, item := range items {
+			result = append(result, item.issue.GetNumber())
+		}
+		return result
+	}
+	if got, want := numbers(marked), []int{6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got marked %v, want %v", got, want)
+	}
+	if got, want := numbers(closed), []int{1, 5, 10, 8}; !reflect.DeepEqual(
+		got, want) {
+		t.Errorf("got closed %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// botState is the information the bot needs to remember across restarts. It
+// is written to the state file every time it changes.
+type botState struct {
+	// Away holds the away periods that people set with bot commands,
+	// keyed by GitHub login. These are in addition to the ones in the
+	// config file.
+	Away map[string][]awayPeriod `json:"away"`
+
+	// Notifications are Slack messages waiting for their recipients'
+	// Do Not Disturb periods to end.
+	Notifications []queuedNotification `json:"notifications"`
+
+	// Reminders records when each reviewer was last reminded about each
+	// pull request. It's keyed by prKey, and then by GitHub login.
+	Reminders map[string]map[string]time.Time `json:"reminders"`
+
+	// MergeReady holds the head commit of each pull request that was
+	// last announced as ready to merge, keyed by prKey.
+	MergeReady map[string]string `json:"mergeReady"`
+
+	// MergeFailed holds the head commit of each pull request whose author
+	// was last told that it couldn't be auto-merged, keyed by prKey.
+	MergeFailed map[string]string `json:"mergeFailed"`
+
+	// MergeQueues holds the merge queue of each repository, keyed by
+	// repository name. The first pull request in each queue is the one
+	// being merged.
+	MergeQueues map[string][]queuedPR `json:"mergeQueues"`
+
+	// MergeQueueEjected holds the head commit at which each pull request
+	// was ejected from its merge queue, keyed by prKey.
+	MergeQueueEjected map[string]string `json:"mergeQueueEjected"`
+
+	// Backports holds the branches that each unmerged pull request should
+	// be backported to once it's merged, keyed by prKey. Entries are
+	// removed when the backports are done, or when the pull request is
+	// closed without being merged.
+	Backports map[string][]string `json:"backports"`
+
+	// Conflicts holds the pull requests that conflict with their base
+	// branch, keyed by prKey.
+	Conflicts map[string]bool `json:"conflicts"`
+
+	// CIFailures holds the CI failure notifications sent to PR authors,
+	// keyed by ciFailureKey of the commit the checks failed on and the PR.
+	CIFailures map[string]ciFailure `json:"ciFailures"`
+
+	// PRThreads holds the Slack message that follows each pull request,
+	// keyed by prKey.
+	PRThreads map[string]prThread `json:"prThreads"`
+
+	// Digests holds the date, in their own timezone, on which each person
+	// was last sent their daily digest, keyed by GitHub login.
+	Digests map[string]string `json:"digests"`
+
+	// HealthReportWeek is the ISO week (e.g., "2026-W42") in which the
+	// review health report was last sent.
+	HealthReportWeek string `json:"healthReportWeek"`
+
+	// ReviewActivity holds the recent review activity that the review
+	// health report is built from.
+	ReviewActivity []reviewActivity `json:"reviewActivity"`
+
+	// ReviewActivityStart is when the bot started recording review
+	// activity. The health report is held until it covers a full week.
+	ReviewActivityStart time.Time `json:"reviewActivityStart"`
+
+	// ReviewProgress holds the review progress of each open pull request,
+	// keyed by prKey.
+	ReviewProgress map[string]reviewProgress `json:"reviewProgress"`
+
+	// ReviewsStarted records when each reviewer said they started
+	// reviewing each pull request. It's keyed by prKey, and then by
+	// GitHub login.
+	ReviewsStarted map[string]map[string]time.Time `json:"reviewsStarted"`
+
+	// ReviewPasses holds the people who passed on reviewing each pull
+	// request, and why, keyed by prKey.
+	ReviewPasses map[string][]reviewPass `json:"reviewPasses"`
+
+	// ReleaseDownloadsDate is the date on which release download counts
+	// were last recorded.
+	ReleaseDownloadsDate string `json:"releaseDownloadsDate"`
+
+	// FirstTimePRs holds the pull requests opened by first-time
+	// contributors, keyed by prKey.
+	FirstTimePRs map[string]bool `json:"firstTimePRs"`
+
+	// TriagedIssues holds the issues waiting for a response from the
+	// team, keyed by itemKey.
+	TriagedIssues map[string]triagedIssue `json:"triagedIssues"`
+
+	// StaleItems holds when each issue and pull request that's currently
+	// marked stale was marked, keyed by "repo#number".
+	StaleItems map[string]time.Time `json:"staleItems"`
+}
+
+var (
+	// statePath is where the bot's state is persisted. It should be on a
+	// volume that survives restarts of the container.
+	statePath = "state.json"
+
+	state     botState
+	stateLock sync.Mutex
+)
+
+// loadState reads the bot's state from statePath. A missing state file isn't
+// an error, since it just means the bot hasn't saved anything yet.
+func loadState() error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &state)
+}
+
+// viewState calls fn with the bot's state, which fn must not modify.
+func viewState(fn func(s *botState)) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	fn(&state)
+}
+
+// updateState calls fn to modify the bot's state, and then persists the
+// result.
+func updateState(fn func(s *botState)) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	fn(&state)
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		log.WithError(err).Warn("unable to serialize bot state")
+		return
+	}
+
+	// Write to a temporary file first so that a crash mid-write doesn't
+	// corrupt the existing state.
+	tmpPath := statePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.WithError(err).Warnf("unable to write %s", tmpPath)
+		return
+	}
+	if err := os.Rename(tmpPath, statePath); err != nil {
+		log.WithError(err).Warnf("unable to replace %s", statePath)
+	}
+}
+
+// forgetClosedPRs removes the state kept about pull requests that are no
+// longer open. open holds the prKey of every open pull request.
+func forgetClosedPRs(open map[string]bool) {
+	updateState(func(s *botState) {
+		for key := range s.Reminders {
+			if !open[key] {
+				delete(s.Reminders, key)
+			}
+		}
+		for key := range s.MergeReady {
+			if !open[key] {
+				delete(s.MergeReady, key)
+			}
+		}
+		for key := range s.MergeFailed {
+			if !open[key] {
+				delete(s.MergeFailed, key)
+			}
+		}
+		for key := range s.MergeQueueEjected {
+			if !open[key] {
+				delete(s.MergeQueueEjected, key)
+			}
+		}
+		for key := range s.Conflicts {
+			if !open[key] {
+				delete(s.Conflicts, key)
+			}
+		}
+		for key, failure := range s.CIFailures {
+			if !open[failure.PR] {
+				delete(s.CIFailures, key)
+			}
+		}
+		for key := range s.PRThreads {
+			if !open[key] {
+				delete(s.PRThreads, key)
+			}
+		}
+		for key := range s.ReviewsStarted {
+			if !open[key] {
+				delete(s.ReviewsStarted, key)
+			}
+		}
+		for key := range s.ReviewProgress {
+			if !open[key] {
+				delete(s.ReviewProgress, key)
+			}
+		}
+		for key := range s.ReviewPasses {
+			if !open[key] {
+				delete(s.ReviewPasses, key)
+			}
+		}
+		for key := range s.FirstTimePRs {
+			if !open[key] {
+				delete(s.FirstTimePRs, key)
+			}
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// triagedIssue is an issue that's waiting for a response from the team.
+type triagedIssue struct {
+	Triager  string    `json:"triager"`
+	Assigned time.Time `json:"assigned"`
+	Reminded time.Time `json:"reminded,omitempty"`
+}
+
+var triageRotation = newRotation()
+
+// triageIssue assigns a newly opened issue from someone outside the team to
+// the next available person in the triage rotation, labels it according to the
+// configured keyword rules, and posts it to the triage channel. The issue is
+// then tracked until someone on the team responds, so that the triager can be
+// reminded if no one does.
+func triageIssue(client *github.Client, slackClient *slack.Client, repo string,
+	issue *github.Issue) {
+	if config.Triage == nil {
+		return
+	}
+	login := issue.User.GetLogin()
+	members, committers := getTeamMembers(client)
+	if userInList(&login, members) || userInList(&login, committers) ||
+		strings.HasSuffix(login, "[bot]") {
+		return
+	}
+
+	key := itemKey(repo, issue.GetNumber())
+	labels := triageLabels(issue)
+	if len(labels) > 0 {
+		_, _, err := client.Issues.AddLabelsToIssue(ctx(), "kelda", repo,
+			issue.GetNumber(), labels)
+		if err != nil {
+			log.WithError(err).Warnf("unable to label %s", key)
+		}
+	}
+
+	rotation := config.Triage.Rotation
+	if len(rotation) == 0 {
+		rotation = members
+	}
+	skip := func(candidate string) bool { return candidate == login }
+	// Triage doesn't count against the review limit, so only people who
+	// are away are passed over.
+	available := func(candidate string) bool {
+		return !isAway(candidate, time.Now())
+	}
+	preferred := func(string) bool { return true }
+	triager, fellBack := pickFromRotation(slackClient, rotation,
+		triageRotation, skip, available, preferred)
+	if fellBack {
+		log.Warnf("everyone in the triage rotation is away; falling back "+
+			"to %s for %s", triager, key)
+	}
+
+	if triager != "" {
+		log.Infof("Assigning %s to %s for triage", key, triager)
+		_, _, err := client.Issues.AddAssignees(ctx(), "kelda", repo,
+			issue.GetNumber(), []string{triager})
+		if err != nil {
+			log.WithError(err).Warnf("unable to assign %s to %s", key,
+				triager)
+		}
+		notifyDuringWorkingHours(slackClient, triager, fmt.Sprintf(
+			"You've been asked to triage <%s|%s: %s> by %s.",
+			issue.GetHTMLURL(), key, issue.GetTitle(), login))
+		updateState(func(s *botState) {
+			if s.TriagedIssues == nil {
+				s.TriagedIssues = map[string]triagedIssue{}
+			}
+			s.TriagedIssues[key] = triagedIssue{
+				Triager:  triager,
+				Assigned: time.Now(),
+			}
+		})
+	} else {
+		log.Warnf("no one is in the triage rotation for %s", key)
+	}
+
+	if config.Triage.Channel != "" {
+		text := fmt.Sprintf(":inbox_tray: New issue <%s|%s: %s> by %s",
+			issue.GetHTMLURL(), key, issue.GetTitle(), login)
+		if triager != "" {
+			text += fmt.Sprintf(", assigned to %s", slackMention(triager))
+		}
+		if len(labels) > 0 {
+			text += fmt.Sprintf(" (labeled %s)", strings.Join(labels, ", "))
+		}
+		postToChannel(slackClient, config.Triage.Channel, text)
+	}
+}
+
+// triageLabels returns the labels whose keywords appear in the title or body
+// of the given issue.
+func triageLabels(issue *github.Issue) []string {
+	text := strings.ToLower(issue.GetTitle() + "\n" + issue.GetBody())
+	var labels []string
+	for _, rule := range config.Triage.Labels {
+		for _, keyword := range rule.Keywords {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				labels = append(labels, rule.Label)
+				break
+			}
+		}
+	}
+	return labels
+}
+
+// remindTriagers reminds the triager of each tracked issue that has gone
+// config.Triage.SLAHours of their working hours without a response from the
+// team. Issues stop being tracked once they're closed or someone on the team
+// comments on them.
+func remindTriagers(client *github.Client, slackClient *slack.Client) {
+	if config.Triage == nil {
+		return
+	}
+
+	var keys []string
+	issues := map[string]triagedIssue{}
+	viewState(func(s *botState) {
+		for key, issue := range s.TriagedIssues {
+			keys = append(keys, key)
+			issues[key] = issue
+		}
+	})
+
+	members, committers := getTeamMembers(client)
+	team := append(append([]string{}, members...), committers...)
+	now := time.Now()
+	threshold := time.Duration(config.Triage.SLAHours) * time.Hour
+	for _, key := range keys {
+		tracked := issues[key]
+		repo, number, err := parseItemKey(key)
+		if err != nil {
+			log.WithError(err).Warn("invalid triaged issue")
+			forgetTriagedIssue(key)
+			continue
+		}
+
+		responded, err := teamResponded(client, repo, number, team)
+		if err != nil {
+			log.WithError(err).Warnf("unable to check for responses to %s",
+				key)
+			continue
+		}
+		if responded {
+			forgetTriagedIssue(key)
+			continue
+		}
+		if threshold == 0 {
+			continue
+		}
+
+		waited := tracked.Assigned
+		if tracked.Reminded.After(waited) {
+			waited = tracked.Reminded
+		}
+		loc := userLocation(slackClient, tracked.Triager)
+		if !inWorkingHours(now, loc) ||
+			businessHoursBetween(waited, now, loc) < threshold {
+			continue
+		}
+
+		hours := businessHoursBetween(tracked.Assigned, now, loc).Hours()
+		notifyUser(slackClient, tracked.Triager, fmt.Sprintf(
+			"Reminder: <https://github.com/kelda/%s/issues/%d|%s> has been "+
+				"waiting %.0f working hours for a response from the team.",
+			repo, number, key, hours))
+		updateState(func(s *botState) {
+			if issue, ok := s.TriagedIssues[key]; ok {
+				issue.Reminded = now
+				s.TriagedIssues[key] = issue
+			}
+		})
+	}
+}
+
+// teamResponded returns whether the given issue is closed, or has a comment
+// from someone on the team. The bot's own comments don't count, even when the
+// bot acts as a team member's account.
+func teamResponded(client *github.Client, repo string, number int,
+	team []string) (bool, error) {
+	issue, _, err := client.Issues.Get(ctx(), "kelda", repo, number)
+	if err != nil {
+		return false, err
+	}
+	if issue.GetState() == "closed" {
+		return true, nil
+	}
+
+	opts := &github.IssueListCommentsOptions{}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx(), "kelda",
+			repo, number, opts)
+		if err != nil {
+			return false, err
+		}
+		for _, comment := range comments {
+			login := comment.User.GetLogin()
+			if userInList(&login, team) && !isBot(client, login) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// forgetTriagedIssue stops tracking the issue with the given key.
+func forgetTriagedIssue(key string) {
+	updateState(func(s *botState) {
+		delete(s.TriagedIssues, key)
+	})
+}
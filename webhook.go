@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/sheets/v4"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// githubWebhookSecret is the secret GitHub signs webhook payloads with, which
+// is used to verify that webhook requests actually came from GitHub.
+var githubWebhookSecret string
+
+// readGithubWebhook returns the payload of the given webhook request after
+// verifying that it was signed with githubWebhookSecret.
+func readGithubWebhook(r *http.Request) ([]byte, error) {
+	if githubWebhookSecret == "" {
+		return nil, errors.New("no GitHub webhook secret is configured")
+	}
+	return github.ValidatePayload(r, []byte(githubWebhookSecret))
+}
+
+// handleGithubEvent responds to a webhook event from GitHub.
+func handleGithubEvent(client *github.Client, googleClient *sheets.Service,
+	slackClient *slack.Client, eventType string, payload []byte) {
+	if eventType == "check_run" {
+		handleCheckRun(client, slackClient, payload)
+		return
+	}
+
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		// The webhook is configured to send every event, including
+		// ones the vendored client doesn't know about, so this is
+		// expected.
+		log.WithError(err).Debugf("ignoring %s event", eventType)
+		return
+	}
+
+	switch event := event.(type) {
+	case *github.PullRequestEvent:
+		threadPREvent(slackClient, event, payload)
+		recordPRHealth(event, payload)
+		switch event.GetAction() {
+		case "opened":
+			welcomePR(client, googleClient, event.PullRequest)
+			checkDCO(client, event.PullRequest)
+			checkDescription(client, event.PullRequest)
+			checkMergeableLater(client, slackClient, event.PullRequest)
+		case "synchronize":
+			checkDCO(client, event.PullRequest)
+			checkDescription(client, event.PullRequest)
+			checkMergeableLater(client, slackClient, event.PullRequest)
+			handleStaleActivity(client, event.Repo.GetName(),
+				event.GetNumber(), nil, event.Sender.GetLogin())
+		case "reopened":
+			checkDescription(client, event.PullRequest)
+			checkMergeableLater(client, slackClient, event.PullRequest)
+			handleStaleActivity(client, event.Repo.GetName(),
+				event.GetNumber(), nil, event.Sender.GetLogin())
+		case "edited":
+			checkDescription(client, event.PullRequest)
+			handleStaleActivity(client, event.Repo.GetName(),
+				event.GetNumber(), nil, event.Sender.GetLogin())
+		case "closed":
+			if event.PullRequest.GetMerged() {
+				handleMergedPR(client, event.PullRequest)
+				runPendingBackports(client, event.PullRequest)
+			}
+		}
+		runReview(client, slackClient)
+		if config.Repos[event.Repo.GetName()].MergeQueue {
+			advanceMergeQueue(client, slackClient, event.Repo.GetName())
+		}
+	case *github.PullRequestReviewEvent:
+		threadReview(slackClient, event)
+		recordReviewHealth(client, event)
+		handleStaleActivity(client, event.Repo.GetName(),
+			event.PullRequest.GetNumber(), nil, event.Sender.GetLogin())
+		runReview(client, slackClient)
+	case *github.IssuesEvent:
+		switch event.GetAction() {
+		case "opened":
+			welcomeIssue(client, googleClient, event.Repo.GetName(),
+				event.Issue)
+			triageIssue(client, slackClient, event.Repo.GetName(),
+				event.Issue)
+		case "reopened", "edited":
+			handleStaleActivity(client, event.Repo.GetName(),
+				event.Issue.GetNumber(), event.Issue.Labels,
+				event.Sender.GetLogin())
+		}
+	case *github.IssueCommentEvent:
+		handleIssueComment(client, event)
+		if event.GetAction() == "created" {
+			handleStaleActivity(client, event.Repo.GetName(),
+				event.Issue.GetNumber(), event.Issue.Labels,
+				event.Sender.GetLogin())
+		}
+	case *github.PushEvent:
+		handlePush(client, slackClient, event)
+	case *github.StatusEvent:
+		handleStatus(client, slackClient, event)
+	case *github.ReleaseEvent:
+		handleRelease(slackClient, event)
+	case *github.MembershipEvent:
+		if event.GetScope() == "team" && isReviewTeam(event.Team) {
+			handleMembershipChange(client, slackClient,
+				event.Member.GetLogin())
+		}
+	case *github.TeamEvent:
+		renamed := event.Changes != nil && event.Changes.Name != nil &&
+			isReviewTeam(&github.Team{Name: event.Changes.Name.From})
+		if isReviewTeam(event.Team) || renamed {
+			handleTeamChange(client, slackClient)
+		}
+	case *github.OrganizationEvent:
+		if event.Membership != nil && event.Membership.User != nil {
+			handleMembershipChange(client, slackClient,
+				event.Membership.User.GetLogin())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// firstTimeLabel is added to pull requests and issues opened by first-time
+// contributors.
+const firstTimeLabel = "first-time-contributor"
+
+// defaultWelcomeMessage is the comment posted for first-time contributors if
+// the config file doesn't set one.
+const defaultWelcomeMessage = "Thanks for your first contribution to Kelda, " +
+	"@{login}! Please have a look at our [contributing guide]({guide}). " +
+	"Someone from the team will be with you shortly."
+
+// defaultContributingGuide is the contributing guide linked from the welcome
+// comment if the config file doesn't set one.
+const defaultContributingGuide = "https://github.com/kelda/kelda/blob/master/CONTRIBUTING.md"
+
+// firstTimeSheetName is the sheet in the metrics spreadsheet that first-time
+// contributions are recorded in.
+var firstTimeSheetName = "First-Time Contributors"
+
+// welcomePR welcomes the author of a newly opened pull request if it's their
+// first contribution to the organization.
+func welcomePR(client *github.Client, googleClient *sheets.Service,
+	pr *github.PullRequest) {
+	if !isNewcomer(client, pr.User.GetLogin(), pr.GetCreatedAt()) {
+		return
+	}
+	updateState(func(s *botState) {
+		if s.FirstTimePRs == nil {
+			s.FirstTimePRs = map[string]bool{}
+		}
+		s.FirstTimePRs[prKey(pr)] = true
+	})
+	welcome(client, googleClient, *pr.Base.Repo.Name, *pr.Number,
+		pr.User.GetLogin(), "pull request", pr.GetHTMLURL())
+}
+
+// welcomeIssue welcomes the author of a newly opened issue if it's their first
+// contribution to the organization.
+func welcomeIssue(client *github.Client, googleClient *sheets.Service,
+	repo string, issue *github.Issue) {
+	if !isNewcomer(client, issue.User.GetLogin(), issue.GetCreatedAt()) {
+		return
+	}
+	welcome(client, googleClient, repo, issue.GetNumber(),
+		issue.User.GetLogin(), "issue", issue.GetHTMLURL())
+}
+
+// isNewcomer returns whether login, who isn't on the team, had no merged pull
+// requests and no issues in the organization before the given time.
+func isNewcomer(client *github.Client, login string, before time.Time) bool {
+	members, _ := getTeamMembers(client)
+	if userInList(&login, members) || strings.HasSuffix(login, "[bot]") {
+		return false
+	}
+
+	cutoff := before.UTC().Format("2006-01-02T15:04:05Z")
+	for _, kind := range []string{"is:pr is:merged", "is:issue"} {
+		query := fmt.Sprintf("org:kelda author:%s %s created:<%s",
+			login, kind, cutoff)
+		result, _, err := client.Search.Issues(ctx(), query, nil)
+		if err != nil {
+			log.WithError(err).Warnf("unable to search for earlier "+
+				"contributions by %s", login)
+			return false
+		}
+		if result.GetTotal() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// welcome comments on and labels a first-time contributor's pull request or
+// issue, and records the contribution in the metrics spreadsheet.
+func welcome(client *github.Client, googleClient *sheets.Service, repo string,
+	number int, login, kind, url string) {
+	log.Infof("Welcoming first-time contributor %s on %s#%d", login, repo,
+		number)
+
+	message := config.WelcomeMessage
+	if message == "" {
+		message = defaultWelcomeMessage
+	}
+	guide := config.ContributingGuide
+	if guide == "" {
+		guide = defaultContributingGuide
+	}
+	body := strings.NewReplacer("{login}", login, "{guide}", guide).
+		Replace(message)
+	_, _, err := client.Issues.CreateComment(ctx(), "kelda", repo, number,
+		&github.IssueComment{Body: &body})
+	if err != nil {
+		log.WithError(err).Warnf("unable to welcome %s on %s#%d", login,
+			repo, number)
+	}
+
+	_, _, err = client.Issues.AddLabelsToIssue(ctx(), "kelda", repo, number,
+		[]string{firstTimeLabel})
+	if err != nil {
+		log.WithError(err).Warnf("unable to label %s#%d", repo, number)
+	}
+
+	header := []interface{}{"Date", "Login", "Repo", "Kind", "URL"}
+	row := []interface{}{time.Now().Format(timeFormat), login, repo, kind, url}
+	if err := ensureSheet(googleClient, firstTimeSheetName, header); err != nil {
+		log.WithError(err).Warnf("unable to create the %s sheet",
+			firstTimeSheetName)
+	} else if err := appendRows(googleClient, firstTimeSheetName,
+		[][]interface{}{row}); err != nil {
+		log.WithError(err).Warnf("unable to record the first-time "+
+			"contribution by %s", login)
+	}
+}
+
+// isFirstTimePR returns whether the given pull request was opened by a
+// first-time contributor.
+func isFirstTimePR(pr *github.PullRequest) bool {
+	firstTime := false
+	viewState(func(s *botState) {
+		firstTime = s.FirstTimePRs[prKey(pr)]
+	})
+	return firstTime
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nlopes/slack"
+)
+
+// Default working hours, in each person's local time, used when the config
+// file doesn't set them.
+const (
+	defaultWorkdayStart = 9
+	defaultWorkdayEnd   = 17
+)
+
+// holidays holds the days, formatted with dateFormat, on which no one is
+// expected to work. It's read from the holiday calendar at startup.
+var holidays = map[string]bool{}
+
+// loadHolidays reads the all-day events in the ICS calendar at path into
+// holidays.
+func loadHolidays(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Long ICS lines are folded onto continuation lines that start with
+	// whitespace, so unfold them before parsing.
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") ||
+			strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	var start, end time.Time
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.SplitN(parts[0], ";", 2)[0]
+		switch name {
+		case "BEGIN":
+			start, end = time.Time{}, time.Time{}
+		case "DTSTART":
+			start = parseICSDate(parts[1])
+		case "DTEND":
+			end = parseICSDate(parts[1])
+		case "END":
+			if parts[1] != "VEVENT" || start.IsZero() {
+				continue
+			}
+			// DTEND is exclusive, and is omitted for single-day
+			// events.
+			if !end.After(start) {
+				end = start.AddDate(0, 0, 1)
+			}
+			for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+				holidays[d.Format(dateFormat)] = true
+			}
+		}
+	}
+	return nil
+}
+
+// parseICSDate parses the date part of an ICS DATE or DATE-TIME value, and
+// returns the zero time if it's malformed.
+func parseICSDate(value string) time.Time {
+	if len(value) < 8 {
+		return time.Time{}
+	}
+	t, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		log.WithError(err).Warnf("ignoring malformed calendar date %q", value)
+		return time.Time{}
+	}
+	return t
+}
+
+// isWorkday returns whether the day containing t, in t's location, is a
+// weekday that isn't a holiday.
+func isWorkday(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !holidays[t.Format(dateFormat)]
+}
+
+// workdayHours returns the configured start and end of the working day, as
+// hours in local time.
+func workdayHours() (start, end int) {
+	start, end = config.WorkdayStart, config.WorkdayEnd
+	if start == 0 && end == 0 {
+		return defaultWorkdayStart, defaultWorkdayEnd
+	}
+	return start, end
+}
+
+// inWorkingHours returns whether t is within working hours in the given
+// location.
+func inWorkingHours(t time.Time, loc *time.Location) bool {
+	t = t.In(loc)
+	start, end := workdayHours()
+	return isWorkday(t) && t.Hour() >= start && t.Hour() < end
+}
+
+// nextWorkingTime returns the earliest time at or after t that's within
+// working hours in the given location.
+func nextWorkingTime(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	start, end := workdayHours()
+	for i := 0; i < 366; i++ {
+		y, m, d := t.Date()
+		workStart := time.Date(y, m, d, start, 0, 0, 0, loc)
+		workEnd := time.Date(y, m, d, end, 0, 0, 0, loc)
+		if isWorkday(workStart) && t.Before(workEnd) {
+			if t.Before(workStart) {
+				return workStart
+			}
+			return t
+		}
+		t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	}
+	// Every day of the coming year is a holiday, so give up on waiting.
+	return t
+}
+
+// businessHoursBetween returns how much working time, in the given location,
+// passed between from and to.
+func businessHoursBetween(from, to time.Time, loc *time.Location) time.Duration {
+	from, to = from.In(loc), to.In(loc)
+	startHour, endHour := workdayHours()
+
+	var total time.Duration
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !isWorkday(day) {
+			continue
+		}
+		y, m, d := day.Date()
+		workStart := time.Date(y, m, d, startHour, 0, 0, 0, loc)
+		workEnd := time.Date(y, m, d, endHour, 0, 0, 0, loc)
+		if workStart.Before(from) {
+			workStart = from
+		}
+		if workEnd.After(to) {
+			workEnd = to
+		}
+		if workEnd.After(workStart) {
+			total += workEnd.Sub(workStart)
+		}
+	}
+	return total
+}
+
+// slackTimezoneTTL is how long a timezone looked up from Slack is cached, so
+// that people who travel are eventually picked up in their new timezone.
+const slackTimezoneTTL = 24 * time.Hour
+
+type cachedTimezone struct {
+	name      string
+	fetchedAt time.Time
+}
+
+var (
+	slackTimezones     = map[string]cachedTimezone{}
+	slackTimezonesLock sync.Mutex
+)
+
+// userLocation returns the timezone of the given GitHub user. It comes from
+// the config file if set there, and otherwise from the user's Slack profile.
+// If neither is known, the default timezone from the config file (or UTC) is
+// used.
+func userLocation(slackClient *slack.Client, login string) *time.Location {
+	name := config.Users[login].Timezone
+	if name == "" {
+		name = slackTimezone(slackClient, login)
+	}
+	if name == "" {
+		name = config.DefaultTimezone
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.WithError(err).Warnf("unknown timezone %q for %s", name, login)
+		return time.UTC
+	}
+	return loc
+}
+
+// slackTimezone returns the timezone name from the Slack profile of the given
+// GitHub user, or the empty string if it's unknown.
+func slackTimezone(slackClient *slack.Client, login string) string {
+	slackID := config.Users[login].Slack
+	if slackID == "" {
+		return ""
+	}
+
+	slackTimezonesLock.Lock()
+	defer slackTimezonesLock.Unlock()
+	cached, ok := slackTimezones[slackID]
+	if ok && time.Since(cached.fetchedAt) < slackTimezoneTTL {
+		return cached.name
+	}
+
+	user, err := slackClient.GetUserInfo(slackID)
+	if err != nil {
+		log.WithError(err).Warnf("unable to get Slack profile of %s", login)
+		return cached.name
+	}
+	slackTimezones[slackID] = cachedTimezone{user.TZ, time.Now()}
+	return user.TZ
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusinessHoursBetween(t *testing.T) {
+	// 2026-10-19 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		holidays []string
+		want     time.Duration
+	}{
+		{
+			name: "within a day",
+			from: at(19, 10, 0),
+			to:   at(19, 12, 30),
+			want: 150 * time.Minute,
+		},
+		{
+			name: "outside working hours",
+			from: at(19, 18, 0),
+			to:   at(20, 8, 0),
+			want: 0,
+		},
+		{
+			name: "overnight",
+			from: at(19, 16, 0),
+			to:   at(20, 10, 0),
+			want: 2 * time.Hour,
+		},
+		{
+			name: "over a weekend",
+			from: at(23, 15, 0),
+			to:   at(26, 11, 0),
+			want: 4 * time.Hour,
+		},
+		{
+			name:     "over a holiday",
+			from:     at(19, 15, 0),
+			to:       at(21, 11, 0),
+			holidays: []string{"2026-10-20"},
+			want:     4 * time.Hour,
+		},
+		{
+			name: "backwards",
+			from: at(20, 12, 0),
+			to:   at(19, 12, 0),
+			want: 0,
+		},
+	}
+
+	for _, test := range tests {
+		holidays = map[string]bool{}
+		for _, day := range test.holidays {
+			holidays[day] = true
+		}
+		got := businessHoursBetween(test.from, test.to, time.UTC)
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+	holidays = map[string]bool{}
+}